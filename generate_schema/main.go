@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var tpl = template.Must(template.New("").Parse(`package supervise
+
+// GENERATED FILE, DO NOT MODIFY
+
+var configDocs = map[string]string{
+{{- range $name, $doc := . }}
+	{{ printf "%q" $name }}: {{ printf "%q" $doc }},
+{{- end }}
+}
+`))
+
+// docText joins the lines of each paragraph of a doc comment
+func docText(g *ast.CommentGroup) string {
+	if g == nil {
+		return ""
+	}
+
+	var paragraphs []string
+	for _, p := range strings.Split(strings.TrimSpace(g.Text()), "\n\n") {
+		paragraphs = append(paragraphs, strings.Join(strings.Fields(p), " "))
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func main() {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		panic(err)
+	}
+
+	fset := token.NewFileSet()
+	docs := map[string]string{}
+	aliases := map[string]string{}
+	for _, f := range files {
+		if strings.HasSuffix(f, "_test.go") || strings.HasPrefix(f, "zzz_") {
+			continue
+		}
+
+		file, err := parser.ParseFile(fset, f, nil, parser.ParseComments)
+		if err != nil {
+			panic(err)
+		}
+
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+
+				doc := ts.Doc
+				if doc == nil && len(gen.Specs) == 1 {
+					doc = gen.Doc
+				}
+				if text := docText(doc); text != "" {
+					docs[ts.Name.Name] = text
+				}
+
+				switch t := ts.Type.(type) {
+				case *ast.Ident:
+					aliases[ts.Name.Name] = t.Name
+				case *ast.StructType:
+					for _, field := range t.Fields.List {
+						text := docText(field.Doc)
+						if text == "" {
+							continue
+						}
+						for _, name := range field.Names {
+							docs[ts.Name.Name+"."+name.Name] = text
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Types defined from another type, such as aliases used to avoid
+	// recursion in UnmarshalJSON, have the same fields
+	for alias, orig := range aliases {
+		for name, doc := range docs {
+			if field, ok := strings.CutPrefix(name, orig+"."); ok {
+				docs[alias+"."+field] = doc
+			}
+		}
+	}
+
+	buf := bytes.Buffer{}
+	if err := tpl.Execute(&buf, docs); err != nil {
+		panic(err)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile("zzz_config_docs.go", src, 0666); err != nil {
+		panic(err)
+	}
+}
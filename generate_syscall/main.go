@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"go/format"
+	"go/types"
 	"html/template"
 	"os"
 	"strings"
@@ -14,27 +15,51 @@ var tpl = template.Must(template.New("").Parse(`package supervise
 
 // GENERATED FILE, DO NOT MODIFY
 
-import "syscall"
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
 
 var signalMap = map[string]syscall.Signal{
-{{- range $name := . }}
+{{- range $name := .Signals }}
 	"{{ . }}": syscall.SIG{{ . }},
 {{- end }}
 }
+
+var syscallMap = map[string]int{
+{{- range $name, $const := .Syscalls }}
+	"{{ $name }}": unix.SYS_{{ $const }},
+{{- end }}
+}
 `))
 
+type symbols struct {
+	Signals  []string
+	Syscalls map[string]string
+}
+
 func main() {
-	pkgs, err := packages.Load(&packages.Config{Mode: packages.NeedTypes}, "syscall")
+	pkgs, err := packages.Load(&packages.Config{Mode: packages.NeedName | packages.NeedTypes}, "syscall", "golang.org/x/sys/unix")
 	if err != nil {
 		panic(err)
 	}
 
-	syms := []string{}
-	scope := pkgs[0].Types.Scope()
-	for _, n := range scope.Names() {
-		o := scope.Lookup(n)
-		if strings.HasPrefix(n, "SIG") && o.Type().String() == "syscall.Signal" {
-			syms = append(syms, strings.TrimPrefix(n, "SIG"))
+	syms := symbols{Syscalls: map[string]string{}}
+	for _, pkg := range pkgs {
+		scope := pkg.Types.Scope()
+		for _, n := range scope.Names() {
+			o := scope.Lookup(n)
+			switch pkg.PkgPath {
+			case "syscall":
+				if strings.HasPrefix(n, "SIG") && o.Type().String() == "syscall.Signal" {
+					syms.Signals = append(syms.Signals, strings.TrimPrefix(n, "SIG"))
+				}
+			case "golang.org/x/sys/unix":
+				if _, ok := o.(*types.Const); ok && strings.HasPrefix(n, "SYS_") {
+					syms.Syscalls[strings.ToLower(strings.TrimPrefix(n, "SYS_"))] = strings.TrimPrefix(n, "SYS_")
+				}
+			}
 		}
 	}
 
@@ -6,9 +6,11 @@ toolchain go1.24.2
 
 require (
 	code.crute.us/mcrute/golib/secrets v0.7.0
+	github.com/BurntSushi/toml v1.6.0
 	github.com/stretchr/testify v1.8.1
 	golang.org/x/sys v0.36.0
 	golang.org/x/tools v0.37.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -65,5 +67,4 @@ require (
 	google.golang.org/protobuf v1.26.0 // indirect
 	gopkg.in/square/go-jose.v2 v2.5.1 // indirect
 	gopkg.in/yaml.v2 v2.2.5 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
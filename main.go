@@ -8,19 +8,69 @@ import (
 	"code.crute.us/mcrute/simplevisor/supervise"
 )
 
+// Config files that are used if --config is not set, in order of
+// preference
+var defaultConfigs = []string{"simplevisor.json", "simplevisor.yaml", "simplevisor.yml", "simplevisor.toml"}
+
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func main() {
-	mode := flag.String("mode", "parent", "mode in which to run simplevisor, internal use only")
+	mode := flag.String("mode", "parent", "mode in which to run simplevisor, parent, check, reload, or schema, child is for internal use only")
 	config := flag.String("config", "simplevisor.json", "config file location")
 	noVault := flag.Bool("no-vault", false, "disable Vault integration entirely")
 	discoverVault := flag.Bool("discover-vault", false, "use DNS SRV to discover Vault address")
+	controlSocket := flag.String("control-socket", "", "path of a unix socket that accepts control commands")
+	watchConfig := flag.Bool("watch-config", false, "reload the config file when it changes")
 	flag.Parse()
 
+	if !flagSet("config") {
+		for _, c := range defaultConfigs {
+			if _, err := os.Stat(c); err == nil {
+				*config = c
+				break
+			}
+		}
+	}
+
 	switch *mode {
 	case "parent":
-		parent := &supervise.SupervisorParent{}
+		parent := &supervise.SupervisorParent{
+			ControlSocket: *controlSocket,
+			WatchConfig:   *watchConfig,
+		}
 		parent.Main(*config, *noVault, *discoverVault)
 	case "child":
 		supervise.ChildMain()
+	case "check":
+		if err := supervise.CheckConfig(*config); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s is valid\n", *config)
+	case "schema":
+		schema, err := supervise.ConfigSchema()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(string(schema))
+	case "reload":
+		if *controlSocket == "" {
+			fmt.Println("Error reloading config, --control-socket is required.")
+			os.Exit(1)
+		}
+		if err := supervise.SendControlCommand(*controlSocket, supervise.ControlReload); err != nil {
+			fmt.Printf("Error reloading config: %s\n", err)
+			os.Exit(1)
+		}
 	default:
 		fmt.Println("Error starting supervisor, invalid mode passed.")
 		os.Exit(1)
@@ -0,0 +1,281 @@
+package supervise
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var capabilityNames = map[string]int{
+	"CHOWN":              unix.CAP_CHOWN,
+	"DAC_OVERRIDE":       unix.CAP_DAC_OVERRIDE,
+	"DAC_READ_SEARCH":    unix.CAP_DAC_READ_SEARCH,
+	"FOWNER":             unix.CAP_FOWNER,
+	"FSETID":             unix.CAP_FSETID,
+	"KILL":               unix.CAP_KILL,
+	"SETGID":             unix.CAP_SETGID,
+	"SETUID":             unix.CAP_SETUID,
+	"SETPCAP":            unix.CAP_SETPCAP,
+	"LINUX_IMMUTABLE":    unix.CAP_LINUX_IMMUTABLE,
+	"NET_BIND_SERVICE":   unix.CAP_NET_BIND_SERVICE,
+	"NET_BROADCAST":      unix.CAP_NET_BROADCAST,
+	"NET_ADMIN":          unix.CAP_NET_ADMIN,
+	"NET_RAW":            unix.CAP_NET_RAW,
+	"IPC_LOCK":           unix.CAP_IPC_LOCK,
+	"IPC_OWNER":          unix.CAP_IPC_OWNER,
+	"SYS_MODULE":         unix.CAP_SYS_MODULE,
+	"SYS_RAWIO":          unix.CAP_SYS_RAWIO,
+	"SYS_CHROOT":         unix.CAP_SYS_CHROOT,
+	"SYS_PTRACE":         unix.CAP_SYS_PTRACE,
+	"SYS_PACCT":          unix.CAP_SYS_PACCT,
+	"SYS_ADMIN":          unix.CAP_SYS_ADMIN,
+	"SYS_BOOT":           unix.CAP_SYS_BOOT,
+	"SYS_NICE":           unix.CAP_SYS_NICE,
+	"SYS_RESOURCE":       unix.CAP_SYS_RESOURCE,
+	"SYS_TIME":           unix.CAP_SYS_TIME,
+	"SYS_TTY_CONFIG":     unix.CAP_SYS_TTY_CONFIG,
+	"MKNOD":              unix.CAP_MKNOD,
+	"LEASE":              unix.CAP_LEASE,
+	"AUDIT_WRITE":        unix.CAP_AUDIT_WRITE,
+	"AUDIT_CONTROL":      unix.CAP_AUDIT_CONTROL,
+	"SETFCAP":            unix.CAP_SETFCAP,
+	"MAC_OVERRIDE":       unix.CAP_MAC_OVERRIDE,
+	"MAC_ADMIN":          unix.CAP_MAC_ADMIN,
+	"SYSLOG":             unix.CAP_SYSLOG,
+	"WAKE_ALARM":         unix.CAP_WAKE_ALARM,
+	"BLOCK_SUSPEND":      unix.CAP_BLOCK_SUSPEND,
+	"AUDIT_READ":         unix.CAP_AUDIT_READ,
+	"PERFMON":            unix.CAP_PERFMON,
+	"BPF":                unix.CAP_BPF,
+	"CHECKPOINT_RESTORE": unix.CAP_CHECKPOINT_RESTORE,
+}
+
+// Securebits flags, see capabilities(7). These are not exported by the
+// unix package.
+var secureBitNames = map[string]int{
+	"noroot":                      1 << 0,
+	"noroot-locked":               1 << 1,
+	"no-setuid-fixup":             1 << 2,
+	"no-setuid-fixup-locked":      1 << 3,
+	"keep-caps":                   1 << 4,
+	"keep-caps-locked":            1 << 5,
+	"no-cap-ambient-raise":        1 << 6,
+	"no-cap-ambient-raise-locked": 1 << 7,
+}
+
+const secureBitKeepCaps = 1 << 4
+
+type CapabilityConfig struct {
+	// Keep is the set of capabilities the job starts with. If not set the
+	// job starts with the capabilities of the supervisor.
+	Keep []string `json:"keep"`
+
+	// Drop removes capabilities from the set, ALL removes every
+	// capability. Drop is applied before Add.
+	Drop []string `json:"drop"`
+
+	// Add adds capabilities to the set, they must be available to the
+	// supervisor.
+	Add []string `json:"add"`
+
+	// Ambient capabilities are kept across exec by jobs that do not run
+	// as root. They must also be in the capability set.
+	Ambient []string `json:"ambient"`
+}
+
+func (c *CapabilityConfig) validate() error {
+	for _, names := range [][]string{c.Keep, c.Drop, c.Add, c.Ambient} {
+		if _, err := parseCapabilities(names); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve computes the capability set and ambient capabilities for the
+// job given the capabilities available to the supervisor
+func (c *CapabilityConfig) resolve(available uint64) (caps []int, ambient []int, err error) {
+	set := available
+	if c.Keep != nil {
+		keep, err := parseCapabilities(c.Keep)
+		if err != nil {
+			return nil, nil, err
+		}
+		if missing := keep &^ available; missing != 0 {
+			return nil, nil, fmt.Errorf("capabilities %s are not available to the supervisor", formatCapabilities(missing))
+		}
+		set = keep
+	}
+
+	drop, err := parseCapabilities(c.Drop)
+	if err != nil {
+		return nil, nil, err
+	}
+	set &^= drop
+
+	add, err := parseCapabilities(c.Add)
+	if err != nil {
+		return nil, nil, err
+	}
+	if missing := add &^ available; missing != 0 {
+		return nil, nil, fmt.Errorf("capabilities %s are not available to the supervisor", formatCapabilities(missing))
+	}
+	set |= add
+
+	amb, err := parseCapabilities(c.Ambient)
+	if err != nil {
+		return nil, nil, err
+	}
+	if missing := amb &^ set; missing != 0 {
+		return nil, nil, fmt.Errorf("ambient capabilities %s are not in the capability set", formatCapabilities(missing))
+	}
+
+	return capabilityList(set), capabilityList(amb), nil
+}
+
+// parseCapabilities parses capability names, with or without the CAP_
+// prefix, into a bitmask. The name ALL includes every capability known
+// to the kernel.
+func parseCapabilities(names []string) (uint64, error) {
+	var mask uint64
+	for _, name := range names {
+		n := strings.TrimPrefix(strings.ToUpper(name), "CAP_")
+		if n == "ALL" {
+			for c := 0; c <= lastCapability(); c++ {
+				mask |= 1 << c
+			}
+			continue
+		}
+		c, ok := capabilityNames[n]
+		if !ok {
+			return 0, fmt.Errorf("unknown capability %s", name)
+		}
+		mask |= 1 << c
+	}
+	return mask, nil
+}
+
+func capabilityList(mask uint64) []int {
+	out := []int{}
+	for c := 0; c < 64; c++ {
+		if mask&(1<<c) != 0 {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func capabilityName(c int) string {
+	for name, v := range capabilityNames {
+		if v == c {
+			return "CAP_" + name
+		}
+	}
+	return strconv.Itoa(c)
+}
+
+func formatCapabilities(mask uint64) string {
+	names := []string{}
+	for _, c := range capabilityList(mask) {
+		names = append(names, capabilityName(c))
+	}
+	return strings.Join(names, ", ")
+}
+
+// lastCapability returns the highest capability supported by the
+// running kernel
+func lastCapability() int {
+	if v, err := os.ReadFile("/proc/sys/kernel/cap_last_cap"); err == nil {
+		if c, err := strconv.Atoi(strings.TrimSpace(string(v))); err == nil {
+			return c
+		}
+	}
+	return unix.CAP_LAST_CAP
+}
+
+func parseSecureBits(names []string) (int, error) {
+	bits := 0
+	for _, name := range names {
+		b, ok := secureBitNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown securebit %s", name)
+		}
+		bits |= b
+	}
+	return bits, nil
+}
+
+// permittedCapabilities returns the permitted capabilities of the
+// calling thread
+func permittedCapabilities() (uint64, error) {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{}
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return 0, err
+	}
+	return uint64(data[1].Permitted)<<32 | uint64(data[0].Permitted), nil
+}
+
+// limitCapabilities drops every capability not in caps from the bounding
+// set and sets the securebits. When caps is not nil keep-caps is also set
+// so that the permitted capabilities survive the change to the job user.
+// This must be called before dropping privileges.
+func limitCapabilities(caps []int, secureBits int) (string, error) {
+	if caps != nil {
+		keep := map[int]bool{}
+		for _, c := range caps {
+			keep[c] = true
+		}
+		for c := 0; c <= lastCapability(); c++ {
+			if keep[c] {
+				continue
+			}
+			if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil {
+				return "bounding set " + capabilityName(c), err
+			}
+		}
+		secureBits |= secureBitKeepCaps
+	}
+
+	if secureBits != 0 {
+		if err := unix.Prctl(unix.PR_SET_SECUREBITS, uintptr(secureBits), 0, 0, 0); err != nil {
+			return "securebits", err
+		}
+	}
+
+	return "", nil
+}
+
+// setCapabilities sets the effective, permitted, and inheritable
+// capabilities to caps and raises the ambient capabilities. This must be
+// called after dropping privileges.
+func setCapabilities(caps []int, ambient []int) (string, error) {
+	var mask uint64
+	for _, c := range caps {
+		mask |= 1 << c
+	}
+
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{}
+	for i := range data {
+		v := uint32(mask >> (32 * i))
+		data[i] = unix.CapUserData{Effective: v, Permitted: v, Inheritable: v}
+	}
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return "capset", err
+	}
+
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return "ambient", err
+	}
+	for _, c := range ambient {
+		if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, uintptr(c), 0, 0); err != nil {
+			return "ambient " + capabilityName(c), err
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,80 @@
+package supervise
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	mask, err := parseCapabilities([]string{"NET_BIND_SERVICE", "cap_chown", "CAP_KILL"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{unix.CAP_CHOWN, unix.CAP_KILL, unix.CAP_NET_BIND_SERVICE}, capabilityList(mask))
+
+	mask, err = parseCapabilities([]string{"ALL"})
+	assert.NoError(t, err)
+	assert.Len(t, capabilityList(mask), lastCapability()+1)
+
+	_, err = parseCapabilities([]string{"FLY"})
+	assert.ErrorContains(t, err, "unknown capability FLY")
+}
+
+func TestCapabilityConfigResolve(t *testing.T) {
+	available, _ := parseCapabilities([]string{"CHOWN", "KILL", "NET_BIND_SERVICE", "SETUID"})
+
+	for _, tc := range []struct {
+		config  CapabilityConfig
+		caps    []int
+		ambient []int
+	}{
+		{CapabilityConfig{}, []int{unix.CAP_CHOWN, unix.CAP_KILL, unix.CAP_SETUID, unix.CAP_NET_BIND_SERVICE}, []int{}},
+		{CapabilityConfig{Keep: []string{}}, []int{}, []int{}},
+		{CapabilityConfig{Keep: []string{"CHOWN", "KILL"}, Drop: []string{"KILL"}}, []int{unix.CAP_CHOWN}, []int{}},
+		{
+			CapabilityConfig{Drop: []string{"ALL"}, Add: []string{"NET_BIND_SERVICE"}, Ambient: []string{"NET_BIND_SERVICE"}},
+			[]int{unix.CAP_NET_BIND_SERVICE},
+			[]int{unix.CAP_NET_BIND_SERVICE},
+		},
+	} {
+		caps, ambient, err := tc.config.resolve(available)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.caps, caps)
+		assert.Equal(t, tc.ambient, ambient)
+	}
+
+	for msg, c := range map[string]CapabilityConfig{
+		"capabilities CAP_SYS_ADMIN are not available to the supervisor":   {Add: []string{"SYS_ADMIN"}},
+		"capabilities CAP_NET_RAW are not available to the supervisor":     {Keep: []string{"CHOWN", "NET_RAW"}},
+		"ambient capabilities CAP_KILL are not in the capability set":      {Drop: []string{"KILL"}, Ambient: []string{"KILL"}},
+		"ambient capabilities CAP_SYS_ADMIN are not in the capability set": {Ambient: []string{"SYS_ADMIN"}},
+	} {
+		_, _, err := c.resolve(available)
+		assert.ErrorContains(t, err, msg)
+	}
+}
+
+func TestParseSecureBits(t *testing.T) {
+	bits, err := parseSecureBits([]string{"noroot", "noroot-locked", "no-cap-ambient-raise"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0x43, bits)
+
+	_, err = parseSecureBits([]string{"root"})
+	assert.ErrorContains(t, err, "unknown securebit root")
+}
+
+func TestCommandUnmarshalCapabilities(t *testing.T) {
+	c := &Command{}
+	assert.NoError(t, json.Unmarshal([]byte(`{
+		"capabilities": {"drop": ["ALL"], "add": ["NET_BIND_SERVICE"], "ambient": ["NET_BIND_SERVICE"]},
+		"securebits": ["noroot"],
+		"no-new-privs": true
+	}`), c))
+	assert.Equal(t, []string{"ALL"}, c.Capabilities.Drop)
+	assert.Equal(t, []string{"noroot"}, c.SecureBits)
+	assert.True(t, c.NoNewPrivs)
+
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"capabilities": {"add": ["FLY"]}}`), &Command{}), "unknown capability FLY")
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"securebits": ["root"]}`), &Command{}), "unknown securebit root")
+}
@@ -0,0 +1,277 @@
+package supervise
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	cgroupMount = "/sys/fs/cgroup"
+
+	// The supervisor, and any processes in its cgroup at startup, are
+	// moved into this leaf because cgroup v2 does not allow processes
+	// in a cgroup that delegates controllers to its children.
+	cgroupSupervisorLeaf = "supervisor"
+
+	cgroupRemoveAttempts = 20
+	cgroupRemoveInterval = 50 * time.Millisecond
+)
+
+var (
+	cgroupControllers = []string{"cpu", "memory", "pids"}
+	cgroupNameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+	cgroupSizeUnits   = map[byte]uint64{
+		'K': 1 << 10,
+		'M': 1 << 20,
+		'G': 1 << 30,
+		'T': 1 << 40,
+	}
+)
+
+type CgroupConfig struct {
+	// MemoryMax is the hard memory limit for the job, above which the
+	// OOM killer is invoked. Either a number of bytes with an optional
+	// K, M, G, or T suffix, or max.
+	MemoryMax string `json:"memory-max"`
+
+	// MemoryHigh is the memory throttling limit for the job, in the same
+	// format as MemoryMax.
+	MemoryHigh string `json:"memory-high"`
+
+	// CPUMax is the CPU bandwidth limit in the cgroup cpu.max format,
+	// "$QUOTA $PERIOD" in microseconds where quota may be max. For example
+	// "50000 100000" allows half of one CPU.
+	CPUMax string `json:"cpu-max"`
+
+	// CPUWeight is the relative CPU weight of the job from 1 to 10000.
+	// The kernel default is 100.
+	CPUWeight int `json:"cpu-weight"`
+
+	// PidsMax is the maximum number of processes in the job.
+	PidsMax int `json:"pids-max"`
+}
+
+// files returns the cgroup interface files and values to write for the
+// config, validating the config in the process.
+func (c *CgroupConfig) files() (map[string]string, error) {
+	out := map[string]string{}
+
+	for file, v := range map[string]string{"memory.max": c.MemoryMax, "memory.high": c.MemoryHigh} {
+		if v == "" {
+			continue
+		}
+		size, err := parseCgroupSize(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", file, err)
+		}
+		out[file] = size
+	}
+
+	if c.CPUMax != "" {
+		fields := strings.Fields(c.CPUMax)
+		if len(fields) < 1 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid cpu.max %q, expected \"$QUOTA $PERIOD\"", c.CPUMax)
+		}
+		if fields[0] != "max" {
+			if n, err := strconv.ParseUint(fields[0], 10, 64); err != nil || n == 0 {
+				return nil, fmt.Errorf("invalid cpu.max quota %s", fields[0])
+			}
+		}
+		if len(fields) == 2 {
+			if n, err := strconv.ParseUint(fields[1], 10, 64); err != nil || n == 0 {
+				return nil, fmt.Errorf("invalid cpu.max period %s", fields[1])
+			}
+		}
+		out["cpu.max"] = strings.Join(fields, " ")
+	}
+
+	if c.CPUWeight != 0 {
+		if c.CPUWeight < 1 || c.CPUWeight > 10000 {
+			return nil, fmt.Errorf("invalid cpu.weight %d, must be from 1 to 10000", c.CPUWeight)
+		}
+		out["cpu.weight"] = strconv.Itoa(c.CPUWeight)
+	}
+
+	if c.PidsMax != 0 {
+		if c.PidsMax < 0 {
+			return nil, fmt.Errorf("invalid pids.max %d", c.PidsMax)
+		}
+		out["pids.max"] = strconv.Itoa(c.PidsMax)
+	}
+
+	return out, nil
+}
+
+func parseCgroupSize(v string) (string, error) {
+	if v == "max" {
+		return v, nil
+	}
+
+	num, mult := v, uint64(1)
+	if m, ok := cgroupSizeUnits[v[len(v)-1]]; ok {
+		num, mult = v[:len(v)-1], m
+	}
+
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil || n > math.MaxUint64/mult {
+		return "", fmt.Errorf("invalid size %s", v)
+	}
+
+	return strconv.FormatUint(n*mult, 10), nil
+}
+
+// CgroupManager creates cgroups for jobs beneath the cgroup that the
+// supervisor was started in, which must be a delegated cgroup v2
+// hierarchy.
+type CgroupManager struct {
+	root string
+	seq  atomic.Int64
+}
+
+func NewCgroupManager() (*CgroupManager, error) {
+	rel, err := readSelfCgroup("/proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+
+	root := filepath.Join(cgroupMount, rel)
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("NewCgroupManager: cgroup v2 is not available: %w", err)
+	}
+
+	return setupCgroupManager(root)
+}
+
+// readSelfCgroup returns the cgroup v2 path of the current process
+func readSelfCgroup(path string) (string, error) {
+	cg, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("NewCgroupManager: unable to read cgroup: %w", err)
+	}
+
+	for _, line := range strings.Split(string(cg), "\n") {
+		if rel, ok := strings.CutPrefix(line, "0::"); ok {
+			return rel, nil
+		}
+	}
+
+	return "", fmt.Errorf("NewCgroupManager: not in a cgroup v2 hierarchy")
+}
+
+func setupCgroupManager(root string) (*CgroupManager, error) {
+	leaf := filepath.Join(root, cgroupSupervisorLeaf)
+	if err := os.Mkdir(leaf, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("NewCgroupManager: unable to create supervisor cgroup: %w", err)
+	}
+
+	procs, err := os.ReadFile(filepath.Join(root, "cgroup.procs"))
+	if err != nil {
+		return nil, fmt.Errorf("NewCgroupManager: unable to read cgroup processes: %w", err)
+	}
+	for _, pid := range strings.Fields(string(procs)) {
+		if err := writeCgroupFile(leaf, "cgroup.procs", pid); err != nil {
+			return nil, fmt.Errorf("NewCgroupManager: unable to move process to supervisor cgroup: %w", err)
+		}
+	}
+
+	available, err := os.ReadFile(filepath.Join(root, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("NewCgroupManager: unable to read controllers: %w", err)
+	}
+
+	enable := []string{}
+	for _, c := range strings.Fields(string(available)) {
+		for _, want := range cgroupControllers {
+			if c == want {
+				enable = append(enable, "+"+c)
+			}
+		}
+	}
+	if err := writeCgroupFile(root, "cgroup.subtree_control", strings.Join(enable, " ")); err != nil {
+		return nil, fmt.Errorf("NewCgroupManager: unable to enable controllers: %w", err)
+	}
+
+	return &CgroupManager{root: root}, nil
+}
+
+// Create creates and configures a new cgroup for a job. Each call
+// creates a distinct cgroup even for the same job name.
+func (m *CgroupManager) Create(name string, c *CgroupConfig) (*jobCgroup, error) {
+	files, err := c.files()
+	if err != nil {
+		return nil, fmt.Errorf("CgroupManager.Create: %w", err)
+	}
+
+	path := filepath.Join(m.root, fmt.Sprintf("%s-%d", cgroupNameInvalid.ReplaceAllString(name, "_"), m.seq.Add(1)))
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, fmt.Errorf("CgroupManager.Create: %w", err)
+	}
+
+	cg := &jobCgroup{path: path}
+	for file, v := range files {
+		if err := writeCgroupFile(path, file, v); err != nil {
+			cg.Remove()
+			return nil, fmt.Errorf("CgroupManager.Create: unable to set %s: %w", file, err)
+		}
+	}
+
+	return cg, nil
+}
+
+type jobCgroup struct {
+	path string
+}
+
+func (g *jobCgroup) AddProcess(pid int) error {
+	return writeCgroupFile(g.path, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// OOMKills returns the number of processes in the cgroup killed by the
+// OOM killer
+func (g *jobCgroup) OOMKills() int {
+	events, err := os.ReadFile(filepath.Join(g.path, "memory.events"))
+	if err != nil {
+		return 0
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(events))
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(scanner.Text(), "oom_kill "); ok {
+			n, _ := strconv.Atoi(v)
+			return n
+		}
+	}
+
+	return 0
+}
+
+// Remove kills any processes remaining in the cgroup and removes it.
+// This should only be called once the job has exited.
+func (g *jobCgroup) Remove() error {
+	// cgroup.kill is only available on Linux 5.14 and later
+	writeCgroupFile(g.path, "cgroup.kill", "1")
+
+	var err error
+	for i := 0; i < cgroupRemoveAttempts; i++ {
+		if err = os.Remove(g.path); err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		time.Sleep(cgroupRemoveInterval)
+	}
+
+	return fmt.Errorf("jobCgroup.Remove: unable to remove %s: %w", g.path, err)
+}
+
+func writeCgroupFile(dir, file, value string) error {
+	return os.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+}
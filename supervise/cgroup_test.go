@@ -0,0 +1,101 @@
+package supervise
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCgroupConfigFiles(t *testing.T) {
+	files, err := (&CgroupConfig{
+		MemoryMax:  "512M",
+		MemoryHigh: "max",
+		CPUMax:     "50000  100000",
+		CPUWeight:  200,
+		PidsMax:    64,
+	}).files()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"memory.max":  "536870912",
+		"memory.high": "max",
+		"cpu.max":     "50000 100000",
+		"cpu.weight":  "200",
+		"pids.max":    "64",
+	}, files)
+
+	files, err = (&CgroupConfig{}).files()
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+
+	for msg, c := range map[string]*CgroupConfig{
+		"invalid memory.max: invalid size lots":            {MemoryMax: "lots"},
+		"invalid memory.high: invalid size M":              {MemoryHigh: "M"},
+		"invalid memory.max: invalid size 20000000000000T": {MemoryMax: "20000000000000T"},
+		"invalid cpu.max quota half":                       {CPUMax: "half"},
+		"invalid cpu.max period 0":                         {CPUMax: "max 0"},
+		`invalid cpu.max "1 2 3"`:                          {CPUMax: "1 2 3"},
+		"invalid cpu.weight 10001":                         {CPUWeight: 10001},
+		"invalid pids.max -1":                              {PidsMax: -1},
+	} {
+		_, err := c.files()
+		assert.ErrorContains(t, err, msg)
+	}
+}
+
+func TestCommandUnmarshalCgroup(t *testing.T) {
+	c := &Command{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"cgroup": {"memory-max": "1G", "pids-max": 10}}`), c))
+	assert.Equal(t, &CgroupConfig{MemoryMax: "1G", PidsMax: 10}, c.Cgroup)
+
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"cgroup": {"cpu-weight": -1}}`), &Command{}), "invalid cpu.weight")
+}
+
+func TestReadSelfCgroup(t *testing.T) {
+	dir := t.TempDir()
+
+	p := filepath.Join(dir, "cgroup")
+	os.WriteFile(p, []byte("0::/system.slice/app.service\n"), 0644)
+	rel, err := readSelfCgroup(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "/system.slice/app.service", rel)
+
+	os.WriteFile(p, []byte("12:memory:/app\n1:name=systemd:/app\n"), 0644)
+	_, err = readSelfCgroup(p)
+	assert.ErrorContains(t, err, "not in a cgroup v2 hierarchy")
+}
+
+func TestCgroupManager(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpuset cpu io memory pids\n"), 0644)
+	os.WriteFile(filepath.Join(root, "cgroup.procs"), []byte("1\n"), 0644)
+
+	m, err := setupCgroupManager(root)
+	assert.NoError(t, err)
+
+	control, _ := os.ReadFile(filepath.Join(root, "cgroup.subtree_control"))
+	assert.Equal(t, "+cpu +memory +pids", string(control))
+	procs, _ := os.ReadFile(filepath.Join(root, cgroupSupervisorLeaf, "cgroup.procs"))
+	assert.Equal(t, "1", string(procs))
+
+	cg, err := m.Create("web server", &CgroupConfig{PidsMax: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "web_server-1"), cg.path)
+
+	pids, _ := os.ReadFile(filepath.Join(cg.path, "pids.max"))
+	assert.Equal(t, "10", string(pids))
+
+	assert.NoError(t, cg.AddProcess(42))
+	procs, _ = os.ReadFile(filepath.Join(cg.path, "cgroup.procs"))
+	assert.Equal(t, "42", string(procs))
+
+	assert.Equal(t, 0, cg.OOMKills())
+	os.WriteFile(filepath.Join(cg.path, "memory.events"), []byte("low 0\nhigh 3\nmax 2\noom 2\noom_kill 1\n"), 0644)
+	assert.Equal(t, 1, cg.OOMKills())
+
+	cg2, err := m.Create("web server", &CgroupConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "web_server-2"), cg2.path)
+}
@@ -2,37 +2,175 @@ package supervise
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
+// Used for command lookup if the job environment has no PATH
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
 func ChildMain() {
+	// Capabilities, securebits, and no_new_privs are per-thread so all
+	// setup must happen on the thread that calls exec
+	runtime.LockOSThread()
+
 	fd := os.NewFile(uintptr(3), "commandPipe")
 	if fd == nil {
-		fmt.Println("childMain: error unable to open parent pipe")
+		fmt.Fprintln(os.Stderr, "childMain: error unable to open parent pipe")
 		os.Exit(1)
 	}
 
+	// Closing the socket on exec is how the parent knows exec succeeded
+	syscall.CloseOnExec(3)
+
 	cmd := &controlMessage{}
 	if err := json.NewDecoder(fd).Decode(&cmd); err != nil {
-		fmt.Printf("childMain: error decoding json: %s", err)
-		os.Exit(1)
+		childFail(fd, "decode", "", err)
 	}
 
-	fd.Close()
 	os.Stdin.Close()
 
-	syscall.Setuid(cmd.User)
-	syscall.Setgid(cmd.Group)
+	if name, err := applyRlimits(cmd.Rlimits); err != nil {
+		childFail(fd, "setrlimit", name, err)
+	}
+
+	if cmd.Namespaces != 0 {
+		if target, err := setupNamespaces(cmd.Namespaces, cmd.Hostname, cmd.Filesystem.Chroot); err != nil {
+			childFail(fd, "namespaces", target, err)
+		}
+	}
+
+	if target, err := setupFilesystem(&cmd.Filesystem); err != nil {
+		childFail(fd, "filesystem", target, err)
+	}
+
+	if cmd.Capabilities != nil || cmd.SecureBits != 0 {
+		if target, err := limitCapabilities(cmd.Capabilities, cmd.SecureBits); err != nil {
+			childFail(fd, "capabilities", target, err)
+		}
+	}
+
+	// An unprivileged supervisor can only run jobs as itself, in which
+	// case there are no privileges to drop
+	if os.Geteuid() == 0 || cmd.User != os.Getuid() || cmd.Group != os.Getgid() {
+		// Supplementary groups and the gid must be changed while still root
+		if err := syscall.Setgroups(cmd.Groups); err != nil {
+			childFail(fd, "setgroups", fmt.Sprint(cmd.Groups), err)
+		}
+		if err := syscall.Setgid(cmd.Group); err != nil {
+			childFail(fd, "setgid", fmt.Sprint(cmd.Group), err)
+		}
+		if err := syscall.Setuid(cmd.User); err != nil {
+			childFail(fd, "setuid", fmt.Sprint(cmd.User), err)
+		}
+	}
+
+	if cmd.Capabilities != nil {
+		if target, err := setCapabilities(cmd.Capabilities, cmd.AmbientCapabilities); err != nil {
+			childFail(fd, "capabilities", target, err)
+		}
+	}
 
 	// Start a session so signals are correctly delivered even to shell
 	// subprocesses
-	_, err := syscall.Setsid()
+	if _, err := syscall.Setsid(); err != nil {
+		childFail(fd, "setsid", "", err)
+	}
+
+	if cmd.Umask != nil {
+		syscall.Umask(int(*cmd.Umask))
+	}
+
+	if cmd.WorkDir != "" {
+		if err := syscall.Chdir(cmd.WorkDir); err != nil {
+			childFail(fd, "chdir", cmd.WorkDir, err)
+		}
+	}
+
+	if len(cmd.Command) == 0 {
+		childFail(fd, "exec", "", errors.New("empty command"))
+	}
+
+	// Lookup happens after dropping privileges so that the job user must
+	// be able to execute the command
+	bin, err := lookPath(cmd.Command[0], cmd.Environment)
 	if err != nil {
-		fmt.Printf("childMain: error starting session: %s", err)
-		os.Exit(1)
+		childFail(fd, "exec", cmd.Command[0], err)
+	}
+
+	// Seccomp filters can only be installed by unprivileged processes
+	// with no_new_privs set
+	if cmd.NoNewPrivs || cmd.Seccomp != nil {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			childFail(fd, "no_new_privs", "", err)
+		}
+	}
+
+	if cmd.Seccomp != nil {
+		if err := installSeccomp(cmd.Seccomp); err != nil {
+			childFail(fd, "seccomp", "", err)
+		}
+	}
+
+	err = syscall.Exec(bin, cmd.Command, cmd.Environment)
+	childFail(fd, "exec", bin, err)
+}
+
+// lookPath finds the executable for a command using PATH from the job
+// environment, falling back to defaultPath. Commands containing a slash
+// are used as-is.
+func lookPath(file string, env []string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
 	}
 
-	syscall.Exec(cmd.Command[0], cmd.Command, cmd.Environment)
+	path := defaultPath
+	for _, v := range env {
+		if p, ok := strings.CutPrefix(v, "PATH="); ok {
+			path = p
+		}
+	}
+
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		p := filepath.Join(dir, file)
+		if fi, err := os.Stat(p); err != nil || fi.IsDir() {
+			continue
+		}
+		if err := unix.Access(p, unix.X_OK); err == nil {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("executable file not found in $PATH: %w", syscall.ENOENT)
+}
+
+// childFail reports a failed setup step to the parent and exits without
+// exec'ing the command
+func childFail(fd *os.File, step, target string, err error) {
+	e := &ChildError{
+		Step:    step,
+		Target:  target,
+		Message: err.Error(),
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		e.Errno = int(errno)
+	}
+
+	if err := json.NewEncoder(fd).Encode(e); err != nil {
+		fmt.Fprintf(os.Stderr, "childMain: %s (unable to report to parent: %s)\n", e, err)
+	}
+	fd.Close()
+	os.Exit(1)
 }
@@ -0,0 +1,43 @@
+package supervise
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookPath(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "exec"), []byte("#!/bin/sh\n"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "noexec"), []byte(""), 0644))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "dir"), 0755))
+
+	env := []string{"FOO=bar", "PATH=/nonexistent:" + dir}
+
+	p, err := lookPath("exec", env)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "exec"), p)
+
+	p, err = lookPath("./relative/cmd", env)
+	assert.NoError(t, err)
+	assert.Equal(t, "./relative/cmd", p)
+
+	_, err = lookPath("dir", env)
+	assert.ErrorIs(t, err, syscall.ENOENT)
+
+	_, err = lookPath("missing", env)
+	assert.ErrorContains(t, err, "executable file not found in $PATH")
+
+	// Root can execute anything so this only works for other users
+	if os.Geteuid() != 0 {
+		_, err = lookPath("noexec", env)
+		assert.ErrorIs(t, err, syscall.ENOENT)
+	}
+
+	p, err = lookPath("sh", []string{})
+	assert.NoError(t, err)
+	assert.Contains(t, p, "/sh")
+}
@@ -0,0 +1,59 @@
+package supervise
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configToJSON converts a config file to JSON based on the extension of
+// path. YAML and TOML configs are converted so that they are decoded and
+// validated exactly like JSON configs. Other files are assumed to be
+// JSON.
+func configToJSON(path string, b []byte) ([]byte, error) {
+	var v any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		v = normalizeYAML(v)
+	case ".toml":
+		if err := toml.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+	default:
+		return b, nil
+	}
+
+	return json.Marshal(v)
+}
+
+// normalizeYAML converts mappings with non-string keys, which can not be
+// represented in JSON, to mappings with string keys
+func normalizeYAML(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = normalizeYAML(e)
+		}
+		return v
+	case map[any]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			out[fmt.Sprint(k)] = normalizeYAML(e)
+		}
+		return out
+	case []any:
+		for i, e := range v {
+			v[i] = normalizeYAML(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
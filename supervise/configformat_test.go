@@ -0,0 +1,99 @@
+package supervise
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestConfigFile(t *testing.T, name, cfg string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(cfg), 0600))
+	return path
+}
+
+func TestReadAppConfigYAML(t *testing.T) {
+	cfg, err := ReadAppConfig(writeTestConfigFile(t, "simplevisor.yaml", `
+# Comments are allowed
+env:
+  pass: [HOME]
+jobs:
+  main:
+    - name: web
+      cmd: [/bin/web, --port, "8080"]
+      run-as: nobody:nogroup
+      kill-signal: INT
+      umask: "0027"
+      rlimits:
+        nofile: 18446744073709551615
+      signal-map:
+        HUP: USR2
+signals:
+  USR1: {action: forward, jobs: [web]}
+`))
+	assert.NoError(t, err)
+
+	js := cfg.Jobs.Main[0]
+	assert.Equal(t, []string{"HOME"}, cfg.Environment.PassVariables)
+	assert.Equal(t, []string{"/bin/web", "--port", "8080"}, js.Command)
+	assert.Equal(t, "nobody", js.RunAsUser)
+	assert.Equal(t, "nogroup", js.RunAsGroup)
+	assert.Equal(t, syscall.SIGINT, js.KillSignal)
+	assert.Equal(t, uint32(0027), *js.Umask)
+	assert.Equal(t, Rlimit{Soft: 18446744073709551615, Hard: 18446744073709551615}, js.Rlimits["nofile"])
+	assert.Equal(t, map[syscall.Signal]syscall.Signal{syscall.SIGHUP: syscall.SIGUSR2}, js.SignalMap)
+	assert.Equal(t, []string{"web"}, cfg.Signals[syscall.SIGUSR1].Jobs)
+}
+
+func TestReadAppConfigYAMLErrors(t *testing.T) {
+	_, err := ReadAppConfig(writeTestConfigFile(t, "simplevisor.yml", `
+jobs:
+  main:
+    - cmd: [web]
+      kill_signal: TERM
+      rlimits:
+        1: 10
+`))
+	assert.EqualError(t, err, "readConfig: invalid config:\n"+
+		"jobs.main[0].kill_signal: unknown field\n"+
+		"jobs.main[0]: Command.UnmarshalJSON: invalid rlimit 1, must be one of as, core, cpu, memlock, nofile, nproc, stack")
+
+	_, err = ReadAppConfig(writeTestConfigFile(t, "simplevisor.yaml", "jobs: [\n"))
+	assert.ErrorContains(t, err, "simplevisor.yaml: yaml: line 1")
+}
+
+func TestReadAppConfigTOML(t *testing.T) {
+	cfg, err := ReadAppConfig(writeTestConfigFile(t, "simplevisor.toml", `
+[env]
+pass = ["HOME"]
+
+[[jobs.main]]
+name = "web"
+cmd = ["/bin/web"]
+run-as = "nobody"
+kill-signal = "TERM"
+
+[jobs.main.rlimits]
+nofile = "1024:4096"
+
+[signals]
+HUP = "reload"
+`))
+	assert.NoError(t, err)
+
+	js := cfg.Jobs.Main[0]
+	assert.Equal(t, "nobody", js.RunAsUser)
+	assert.Equal(t, syscall.SIGTERM, js.KillSignal)
+	assert.Equal(t, Rlimit{Soft: 1024, Hard: 4096}, js.Rlimits["nofile"])
+	assert.Equal(t, SignalActionReload, cfg.Signals[syscall.SIGHUP].Action)
+
+	_, err = ReadAppConfig(writeTestConfigFile(t, "simplevisor.toml", `
+[[jobs.main]]
+cmd = ["/bin/web"]
+kil-signal = "TERM"
+`))
+	assert.EqualError(t, err, "readConfig: invalid config:\njobs.main[0].kil-signal: unknown field")
+}
@@ -0,0 +1,210 @@
+package supervise
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Config files in drop-in directories with other extensions are ignored
+var configExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+// dropInDir returns the drop-in directory for the config at path, which
+// is named after the config without its extension, for example
+// simplevisor.d for simplevisor.json
+func dropInDir(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".d"
+}
+
+// configIncludes returns the files to merge into the config at path, in
+// order. These are the matches of the include globs, relative to the
+// config, in the order of the globs and then the files of the drop-in
+// directory in lexical order. Each file is only returned once.
+func configIncludes(path string, include []string) ([]string, error) {
+	seen := map[string]bool{filepath.Clean(path): true}
+	var files []string
+	add := func(f string) {
+		if f = filepath.Clean(f); !seen[f] {
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+
+	for _, pattern := range include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %w", pattern, err)
+		}
+		slices.Sort(matches)
+		for _, m := range matches {
+			add(m)
+		}
+	}
+
+	entries, err := os.ReadDir(dropInDir(path))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !slices.Contains(configExtensions, strings.ToLower(filepath.Ext(e.Name()))) {
+			continue
+		}
+		add(filepath.Join(dropInDir(path), e.Name()))
+	}
+
+	return files, nil
+}
+
+// resolveConfigPaths makes relative paths in a generic JSON config
+// relative to dir, the directory of the config file that they are in
+func resolveConfigPaths(cfg map[string]any, dir string) {
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	if env, ok := cfg["env"].(map[string]any); ok {
+		if files, ok := env["env-file"].([]any); ok {
+			for i, f := range files {
+				if s, ok := f.(string); ok {
+					files[i] = resolve(s)
+				}
+			}
+		}
+	}
+
+	jobs, _ := cfg["jobs"].(map[string]any)
+	templates, _ := jobs["templates"].(map[string]any)
+	defaults, _ := jobs["defaults"].(map[string]any)
+	for _, js := range slices.Concat(rawJobs(cfg), rawObjects(templates), []map[string]any{defaults}) {
+		if s, ok := js["seccomp"].(string); ok && s != seccompDefaultProfile {
+			js["seccomp"] = resolve(s)
+		}
+	}
+}
+
+// rawJobs returns every job object in a generic JSON config
+func rawJobs(cfg map[string]any) []map[string]any {
+	var out []map[string]any
+	jobs, _ := cfg["jobs"].(map[string]any)
+	for _, kind := range []string{"init", "main"} {
+		list, _ := jobs[kind].([]any)
+		for _, js := range list {
+			if m, ok := js.(map[string]any); ok {
+				out = append(out, m)
+			}
+		}
+	}
+	return out
+}
+
+// rawObjects returns the values of a generic JSON object that are
+// objects, in key order
+func rawObjects(m map[string]any) []map[string]any {
+	var out []map[string]any
+	for _, k := range sortedKeys(m) {
+		if o, ok := m[k].(map[string]any); ok {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// mergeConfig merges the generic JSON config src into dst. Objects are
+// merged, lists are appended, and other values are replaced. Signals are
+// merged by signal, the action of a signal in src replaces that of the
+// same signal in dst as a whole. Jobs are merged by name, the fields of a
+// job in src replace those of the job with the same name in dst and other
+// jobs are appended.
+func mergeConfig(dst, src map[string]any) {
+	mergeObject(dst, src, "")
+}
+
+func mergeObject(dst, src map[string]any, path string) {
+	for k, sv := range src {
+		p := joinConfigPath(path, k)
+
+		switch dv := dst[k].(type) {
+		case map[string]any:
+			if sm, ok := sv.(map[string]any); ok && path != "signals" {
+				mergeObject(dv, sm, p)
+				continue
+			}
+		case []any:
+			if sl, ok := sv.([]any); ok {
+				if p == "jobs.init" || p == "jobs.main" {
+					dst[k] = mergeJobs(dv, sl)
+				} else {
+					dst[k] = append(dv, sl...)
+				}
+				continue
+			}
+		}
+
+		dst[k] = sv
+	}
+}
+
+func mergeJobs(dst, src []any) []any {
+	for _, sj := range src {
+		sm, ok := sj.(map[string]any)
+		if !ok {
+			dst = append(dst, sj)
+			continue
+		}
+
+		i := slices.IndexFunc(dst, func(dj any) bool {
+			dm, ok := dj.(map[string]any)
+			return ok && rawJobName(dm) != "" && rawJobName(dm) == rawJobName(sm)
+		})
+		if i < 0 {
+			dst = append(dst, sm)
+			continue
+		}
+
+		dm := dst[i].(map[string]any)
+		for k, v := range sm {
+			dm[k] = v
+		}
+	}
+	return dst
+}
+
+// rawJobName returns the name of a job in a generic JSON config, which
+// defaults to the base name of the command like Command.UnmarshalJSON
+func rawJobName(js map[string]any) string {
+	if name, ok := js["name"].(string); ok && name != "" {
+		return name
+	}
+	if cmd, ok := js["cmd"].([]any); ok && len(cmd) > 0 {
+		if c, ok := cmd[0].(string); ok {
+			return path.Base(c)
+		}
+	}
+	return ""
+}
+
+// removeDisabledJobs removes jobs that are disabled from a generic JSON
+// config
+func removeDisabledJobs(cfg map[string]any) {
+	jobs, _ := cfg["jobs"].(map[string]any)
+	for _, kind := range []string{"init", "main"} {
+		list, ok := jobs[kind].([]any)
+		if !ok {
+			continue
+		}
+		jobs[kind] = slices.DeleteFunc(list, func(js any) bool {
+			m, ok := js.(map[string]any)
+			disabled, _ := m["disabled"].(bool)
+			return ok && disabled
+		})
+	}
+}
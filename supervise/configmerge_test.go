@@ -0,0 +1,175 @@
+package supervise
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestConfigFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, cfg := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		assert.NoError(t, os.WriteFile(path, []byte(cfg), 0600))
+	}
+	return dir
+}
+
+func TestConfigIncludes(t *testing.T) {
+	dir := writeTestConfigFiles(t, map[string]string{
+		"simplevisor.json":       `{}`,
+		"extra/b.json":           `{}`,
+		"extra/a.json":           `{}`,
+		"simplevisor.d/20.yaml":  `{}`,
+		"simplevisor.d/10.toml":  `{}`,
+		"simplevisor.d/README":   ``,
+		"simplevisor.d/a.json~":  ``,
+		"simplevisor.d/sub/x.js": ``,
+	})
+	path := filepath.Join(dir, "simplevisor.json")
+
+	files, err := configIncludes(path, []string{"extra/*.json", "simplevisor.d/20.yaml", "simplevisor.json"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "extra/a.json"),
+		filepath.Join(dir, "extra/b.json"),
+		filepath.Join(dir, "simplevisor.d/20.yaml"),
+		filepath.Join(dir, "simplevisor.d/10.toml"),
+	}, files)
+}
+
+func TestConfigIncludesNoDropInDir(t *testing.T) {
+	files, err := configIncludes(writeTestConfig(t, `{}`), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestReadAppConfigMerge(t *testing.T) {
+	dir := writeTestConfigFiles(t, map[string]string{
+		"simplevisor.json": `{
+			"env": {"pass": ["HOME"], "set": {"A": "1", "B": "2"}},
+			"jobs": {
+				"init": [{"cmd": ["/bin/migrate"]}],
+				"main": [
+					{"name": "web", "cmd": ["/bin/web"], "run-as": "nobody", "seccomp": "default"},
+					{"name": "worker", "cmd": ["/bin/worker"]},
+					{"cmd": ["/bin/cron"]}
+				]
+			},
+			"signals": {"USR1": {"action": "forward", "jobs": ["web"]}, "HUP": "ignore"}
+		}`,
+		"simplevisor.d/10-env.yaml": `
+env:
+  pass: [USER]
+  set: {B: "3"}
+  env-file: [local.env]
+`,
+		"simplevisor.d/20-jobs.json": `{
+			"jobs": {
+				"init": [{"cmd": ["/bin/migrate"], "disabled": true}],
+				"main": [
+					{"name": "web", "cmd": ["/bin/web", "--debug"], "seccomp": "web.json"},
+					{"name": "worker", "disabled": true},
+					{"name": "metrics", "cmd": ["/bin/metrics"]}
+				]
+			},
+			"signals": {"USR1": {"action": "forward", "jobs": ["metrics"]}}
+		}`,
+	})
+
+	cfg, err := ReadAppConfig(filepath.Join(dir, "simplevisor.json"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"HOME", "USER"}, cfg.Environment.PassVariables)
+	assert.Equal(t, map[string]string{"A": "1", "B": "3"}, cfg.Environment.SetVariables)
+	assert.Equal(t, []string{filepath.Join(dir, "simplevisor.d/local.env")}, cfg.Environment.EnvFiles)
+
+	assert.Empty(t, cfg.Jobs.Init)
+
+	var names []string
+	for _, js := range cfg.Jobs.Main {
+		names = append(names, js.Name)
+	}
+	assert.Equal(t, []string{"web", "cron", "metrics"}, names)
+
+	web := cfg.Jobs.Main[0]
+	assert.Equal(t, []string{"/bin/web", "--debug"}, web.Command)
+	assert.Equal(t, "nobody", web.RunAsUser)
+	assert.Equal(t, filepath.Join(dir, "simplevisor.d/web.json"), web.Seccomp)
+
+	assert.Equal(t, []string{"metrics"}, cfg.Signals[syscall.SIGUSR1].Jobs)
+	assert.Equal(t, SignalActionIgnore, cfg.Signals[syscall.SIGHUP].Action)
+}
+
+func TestReadAppConfigInclude(t *testing.T) {
+	dir := writeTestConfigFiles(t, map[string]string{
+		"simplevisor.json": `{
+			"include": ["/nonexistent/*.json", "conf/*.toml"],
+			"jobs": {"main": [{"name": "web", "cmd": ["/bin/web"]}]}
+		}`,
+		"conf/web.toml": `
+[[jobs.main]]
+name = "web"
+umask = "0077"
+`,
+		"simplevisor.d/web.json": `{"jobs": {"main": [{"name": "web", "umask": "0027"}]}}`,
+	})
+
+	cfg, err := ReadAppConfig(filepath.Join(dir, "simplevisor.json"))
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Jobs.Main, 1)
+	assert.Equal(t, uint32(0027), *cfg.Jobs.Main[0].Umask)
+}
+
+func TestReadAppConfigIncludePartialJob(t *testing.T) {
+	dir := writeTestConfigFiles(t, map[string]string{
+		"simplevisor.json": `{
+			"jobs": {"main": [{"name": "web", "cmd": ["/bin/web"], "namespaces": ["uts"], "hostname": "a"}]}
+		}`,
+		"simplevisor.d/web.json": `{"jobs": {"main": [{"name": "web", "hostname": "b"}]}}`,
+	})
+
+	cfg, err := ReadAppConfig(filepath.Join(dir, "simplevisor.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "b", cfg.Jobs.Main[0].Hostname)
+	assert.Equal(t, []string{"uts"}, cfg.Jobs.Main[0].Namespaces)
+
+	// The merged job is checked
+	dir = writeTestConfigFiles(t, map[string]string{
+		"simplevisor.json":       `{"jobs": {"main": [{"name": "web", "cmd": ["/bin/web"]}]}}`,
+		"simplevisor.d/web.json": `{"jobs": {"main": [{"name": "web", "hostname": "b"}]}}`,
+	})
+
+	_, err = ReadAppConfig(filepath.Join(dir, "simplevisor.json"))
+	assert.EqualError(t, err, "readConfig: invalid config:\njobs.main[0]: hostname requires a uts namespace")
+}
+
+func TestReadAppConfigIncludeErrors(t *testing.T) {
+	dir := writeTestConfigFiles(t, map[string]string{
+		"simplevisor.json":        `{"jobs": {"main": [{"cmd": ["web"], "kill_signal": "TERM"}]}}`,
+		"simplevisor.d/a.json":    `{"include": ["*.json"], "jobs": {"main": [{"name": "web", "umask": "99"}]}}`,
+		"simplevisor.d/b.yaml":    `{vault: {adress: "https://vault"}}`,
+		"simplevisor.d/c.json.gz": `not a config`,
+	})
+
+	_, err := ReadAppConfig(filepath.Join(dir, "simplevisor.json"))
+	assert.EqualError(t, err, "readConfig: invalid config:\n"+
+		"jobs.main[0].kill_signal: unknown field\n"+
+		filepath.Join(dir, "simplevisor.d/a.json")+": include: only allowed in the main config\n"+
+		filepath.Join(dir, "simplevisor.d/b.yaml")+": vault.adress: unknown field\n"+
+		filepath.Join(dir, "simplevisor.d/a.json")+": jobs.main[0]: Command.UnmarshalJSON: invalid umask 99")
+}
+
+func TestReadAppConfigIncludeParseError(t *testing.T) {
+	dir := writeTestConfigFiles(t, map[string]string{
+		"simplevisor.json":     `{}`,
+		"simplevisor.d/a.json": `[]`,
+	})
+
+	_, err := ReadAppConfig(filepath.Join(dir, "simplevisor.json"))
+	assert.EqualError(t, err, "readConfig: config "+filepath.Join(dir, "simplevisor.d/a.json")+" must be an object")
+}
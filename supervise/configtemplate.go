@@ -0,0 +1,115 @@
+package supervise
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// resolveJobTemplates applies the job defaults and templates of a generic
+// JSON config to its jobs. Each job starts with the defaults, then the
+// fields of the template it extends, including the templates that
+// template extends, and then its own fields. Fields replace each other
+// as a whole, like jobs merged from included configs. Jobs are updated in
+// place and the errors for unknown templates and templates that extend
+// each other are returned. The defaults and templates are then removed.
+func resolveJobTemplates(cfg map[string]any) []error {
+	jobs, _ := cfg["jobs"].(map[string]any)
+	defaults, _ := jobs["defaults"].(map[string]any)
+	templates, _ := jobs["templates"].(map[string]any)
+
+	var errs []error
+	resolved := map[string]map[string]any{}
+
+	// Every job would have the same name and defaults are applied to
+	// templates, not the other way around
+	for _, field := range []string{"name", "extends"} {
+		if _, ok := defaults[field]; ok {
+			errs = append(errs, fmt.Errorf("jobs.defaults.%s: not allowed in defaults", field))
+		}
+	}
+
+	// resolve returns the fields of a template with the templates that it
+	// extends applied. stack are the templates being resolved, to find
+	// cycles.
+	var resolve func(name string, stack []string) (map[string]any, bool)
+	resolve = func(name string, stack []string) (map[string]any, bool) {
+		if t, ok := resolved[name]; ok {
+			return t, t != nil
+		}
+
+		path := joinConfigPath("jobs.templates", name)
+		if i := slices.Index(stack, name); i >= 0 {
+			errs = append(errs, fmt.Errorf("%s.extends: templates extend each other, %s", path, strings.Join(slices.Concat(stack[i:], []string{name}), " -> ")))
+			resolved[name] = nil
+			return nil, false
+		}
+
+		t := templates[name].(map[string]any)
+		out := map[string]any{}
+		if ext, ok := t["extends"].(string); ok && ext != "" {
+			if _, ok := templates[ext].(map[string]any); !ok {
+				errs = append(errs, fmt.Errorf("%s.extends: unknown template %s", path, ext))
+				resolved[name] = nil
+				return nil, false
+			}
+			base, ok := resolve(ext, append(stack, name))
+			if !ok {
+				resolved[name] = nil
+				return nil, false
+			}
+			maps.Copy(out, base)
+		}
+		maps.Copy(out, t)
+		delete(out, "extends")
+
+		resolved[name] = out
+		return out, true
+	}
+
+	for _, name := range sortedKeys(templates) {
+		if _, ok := templates[name].(map[string]any); ok {
+			resolve(name, nil)
+		}
+	}
+
+	for _, kind := range []string{"init", "main"} {
+		list, _ := jobs[kind].([]any)
+		for i, j := range list {
+			js, ok := j.(map[string]any)
+			if !ok {
+				continue
+			}
+
+			out := map[string]any{}
+			maps.Copy(out, defaults)
+			delete(out, "name")
+			delete(out, "extends")
+			if ext, ok := js["extends"].(string); ok && ext != "" {
+				if _, ok := templates[ext].(map[string]any); !ok {
+					errs = append(errs, fmt.Errorf("jobs.%s[%d].extends: unknown template %s", kind, i, ext))
+					continue
+				}
+				// Errors in the template have already been returned
+				if resolved[ext] == nil {
+					continue
+				}
+				maps.Copy(out, resolved[ext])
+			}
+			maps.Copy(out, js)
+			delete(out, "extends")
+
+			clear(js)
+			maps.Copy(js, out)
+		}
+	}
+
+	// Defaults and templates are only config file shorthand
+	if jobs != nil {
+		delete(jobs, "defaults")
+		delete(jobs, "templates")
+	}
+
+	return errs
+}
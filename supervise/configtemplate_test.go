@@ -0,0 +1,162 @@
+package supervise
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadAppConfigJobTemplates(t *testing.T) {
+	cfg, err := ReadAppConfig(writeTestConfig(t, `{
+		"jobs": {
+			"defaults": {"run-as": "nobody", "kill-signal": "TERM", "no-new-privs": true},
+			"templates": {
+				"service": {"umask": "0027", "rlimits": {"nofile": 1024}},
+				"web": {"extends": "service", "kill-signal": "INT", "env": {"set": {"PORT": "8080"}}}
+			},
+			"init": [{"cmd": ["/bin/migrate"], "run-as": "root"}],
+			"main": [
+				{"name": "web", "cmd": ["/bin/web"], "extends": "web"},
+				{"name": "admin", "cmd": ["/bin/web"], "extends": "web", "env": {"set": {"PORT": "8081"}}},
+				{"name": "worker", "cmd": ["/bin/worker"], "extends": "service", "no-new-privs": false}
+			]
+		}
+	}`))
+	assert.NoError(t, err)
+
+	migrate := cfg.Jobs.Init[0]
+	assert.Equal(t, "root", migrate.RunAsUser)
+	assert.Equal(t, syscall.SIGTERM, migrate.KillSignal)
+	assert.True(t, migrate.NoNewPrivs)
+	assert.Nil(t, migrate.Umask)
+
+	web := cfg.Jobs.Main[0]
+	assert.Equal(t, "nobody", web.RunAsUser)
+	assert.Equal(t, syscall.SIGINT, web.KillSignal)
+	assert.Equal(t, uint32(0027), *web.Umask)
+	assert.Equal(t, Rlimit{Soft: 1024, Hard: 1024}, web.Rlimits["nofile"])
+	assert.Equal(t, map[string]string{"PORT": "8080"}, web.Environment.SetVariables)
+
+	admin := cfg.Jobs.Main[1]
+	assert.Equal(t, syscall.SIGINT, admin.KillSignal)
+	assert.Equal(t, map[string]string{"PORT": "8081"}, admin.Environment.SetVariables)
+
+	worker := cfg.Jobs.Main[2]
+	assert.Equal(t, syscall.SIGTERM, worker.KillSignal)
+	assert.Equal(t, uint32(0027), *worker.Umask)
+	assert.False(t, worker.NoNewPrivs)
+	assert.Nil(t, worker.Environment)
+}
+
+func TestReadAppConfigJobTemplatesPartialJob(t *testing.T) {
+	cfg, err := ReadAppConfig(writeTestConfig(t, `{
+		"jobs": {
+			"templates": {"isolated": {"namespaces": ["uts"]}},
+			"main": [{"cmd": ["/bin/web"], "extends": "isolated", "hostname": "web"}]
+		}
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "web", cfg.Jobs.Main[0].Hostname)
+
+	cfg, err = ReadAppConfig(writeTestConfig(t, `{
+		"jobs": {
+			"defaults": {"namespaces": ["uts"]},
+			"main": [{"cmd": ["/bin/web"], "hostname": "web"}]
+		}
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"uts"}, cfg.Jobs.Main[0].Namespaces)
+
+	// Disabled jobs are not checked
+	cfg, err = ReadAppConfig(writeTestConfig(t, `{
+		"jobs": {
+			"main": [
+				{"cmd": ["/bin/web"]},
+				{"cmd": ["/bin/debug"], "hostname": "debug", "disabled": true}
+			]
+		}
+	}`))
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Jobs.Main, 1)
+
+	// Jobs are checked once templates are applied
+	_, err = ReadAppConfig(writeTestConfig(t, `{
+		"jobs": {
+			"templates": {"named": {"hostname": "web"}},
+			"main": [{"cmd": ["/bin/web"], "extends": "named"}]
+		}
+	}`))
+	assert.EqualError(t, err, "readConfig: invalid config:\njobs.main[0]: hostname requires a uts namespace")
+}
+
+func TestReadAppConfigJobTemplatesDisabled(t *testing.T) {
+	cfg, err := ReadAppConfig(writeTestConfig(t, `{
+		"jobs": {
+			"templates": {"debug": {"disabled": true}},
+			"main": [
+				{"cmd": ["/bin/web"]},
+				{"cmd": ["/bin/profiler"], "extends": "debug"}
+			]
+		}
+	}`))
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Jobs.Main, 1)
+	assert.Equal(t, "web", cfg.Jobs.Main[0].Name)
+}
+
+func TestReadAppConfigJobTemplatesIncluded(t *testing.T) {
+	dir := writeTestConfigFiles(t, map[string]string{
+		"simplevisor.json": `{
+			"jobs": {
+				"defaults": {"run-as": "nobody"},
+				"templates": {"sandboxed": {"seccomp": "default", "kill-signal": "TERM"}},
+				"main": [{"name": "web", "cmd": ["/bin/web"], "extends": "sandboxed"}]
+			}
+		}`,
+		"simplevisor.d/local.yaml": `
+jobs:
+  defaults:
+    run-as: www-data
+  templates:
+    sandboxed:
+      seccomp: profiles/web.json
+`,
+	})
+
+	cfg, err := ReadAppConfig(filepath.Join(dir, "simplevisor.json"))
+	assert.NoError(t, err)
+
+	web := cfg.Jobs.Main[0]
+	assert.Equal(t, "www-data", web.RunAsUser)
+	assert.Equal(t, syscall.SIGTERM, web.KillSignal)
+	assert.Equal(t, filepath.Join(dir, "simplevisor.d/profiles/web.json"), web.Seccomp)
+}
+
+func TestReadAppConfigJobTemplateErrors(t *testing.T) {
+	_, err := ReadAppConfig(writeTestConfig(t, `{
+		"jobs": {
+			"defaults": {"name": "job", "extends": "a", "kill_signal": "TERM"},
+			"templates": {
+				"a": {"extends": "b"},
+				"b": {"extends": "a"},
+				"c": {"extends": "missing"},
+				"d": {"extends": "d"},
+				"e": {"umask": "99"}
+			},
+			"main": [
+				{"cmd": ["/bin/web"], "extends": "nope"},
+				{"cmd": ["/bin/worker"], "extends": "c"}
+			]
+		}
+	}`))
+	assert.EqualError(t, err, "readConfig: invalid config:\n"+
+		"jobs.defaults.kill_signal: unknown field\n"+
+		"jobs.defaults.name: not allowed in defaults\n"+
+		"jobs.defaults.extends: not allowed in defaults\n"+
+		"jobs.templates.a.extends: templates extend each other, a -> b -> a\n"+
+		"jobs.templates.c.extends: unknown template missing\n"+
+		"jobs.templates.d.extends: templates extend each other, d -> d\n"+
+		"jobs.main[0].extends: unknown template nope")
+}
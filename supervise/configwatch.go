@@ -0,0 +1,210 @@
+package supervise
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"code.crute.us/mcrute/simplevisor/supervise/logging"
+	"golang.org/x/sys/unix"
+)
+
+// Kubernetes updates mounted ConfigMaps by replacing this symlink
+const configMapDataLink = "..data"
+
+// Events that indicate that a file in the config directory has been
+// written or replaced
+const configWatchEvents = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_CREATE | unix.IN_DELETE
+
+// configWatch is an inotify instance watching the directory of a config
+// file, its drop-in directory, and the directories of its includes
+type configWatch struct {
+	*os.File
+
+	// dirWd is the watch descriptor of the directory of the config file
+	dirWd int32
+
+	// dropInWd is the watch descriptor of the drop-in directory, or -1 if
+	// it does not exist
+	dropInWd int32
+
+	// includes are the include globs, by the watch descriptor of the
+	// directory that they match files in
+	mu       sync.Mutex
+	includes map[int32][]string
+}
+
+// watchConfig watches the directory containing the config file and the
+// drop-in directory of the config, if it exists. The directory is
+// watched instead of the file so that the watch survives editors and
+// tools that replace the file.
+func watchConfig(path string) (*configWatch, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("watchConfig: %w", err)
+	}
+
+	wd, err := unix.InotifyAddWatch(fd, filepath.Dir(path), configWatchEvents)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("watchConfig: %w", err)
+	}
+
+	dropInWd := -1
+	if fi, err := os.Stat(dropInDir(path)); err == nil && fi.IsDir() {
+		if dropInWd, err = unix.InotifyAddWatch(fd, dropInDir(path), configWatchEvents); err != nil {
+			unix.Close(fd)
+			return nil, fmt.Errorf("watchConfig: %w", err)
+		}
+	}
+
+	// The descriptor is non-blocking so reads use the runtime poller and
+	// are interrupted by closing the file
+	return &configWatch{
+		File:     os.NewFile(uintptr(fd), "inotify"),
+		dirWd:    int32(wd),
+		dropInWd: int32(dropInWd),
+	}, nil
+}
+
+// watchIncludes watches the directories that the include globs of the
+// config at path match files in, replacing the watches of previous
+// globs. Directories that do not exist are not watched, the includes
+// are watched again after each reload.
+func (w *configWatch) watchIncludes(path string, include []string) error {
+	// Fd would make reads blocking so they could not be interrupted
+	conn, err := w.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("configWatch.watchIncludes: %w", err)
+	}
+
+	includes := map[int32][]string{}
+	for _, pattern := range include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		dirs, err := filepath.Glob(filepath.Dir(pattern))
+		if err != nil {
+			return fmt.Errorf("configWatch.watchIncludes: include %s: %w", pattern, err)
+		}
+		for _, dir := range dirs {
+			if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+				continue
+			}
+			var wd int
+			if cerr := conn.Control(func(fd uintptr) {
+				wd, err = unix.InotifyAddWatch(int(fd), dir, configWatchEvents)
+			}); cerr != nil {
+				err = cerr
+			}
+			if err != nil {
+				return fmt.Errorf("configWatch.watchIncludes: %w", err)
+			}
+			includes[int32(wd)] = append(includes[int32(wd)], pattern)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for wd := range w.includes {
+		if _, ok := includes[wd]; !ok && wd != w.dirWd && wd != w.dropInWd {
+			conn.Control(func(fd uintptr) { unix.InotifyRmWatch(int(fd), uint32(wd)) })
+		}
+	}
+	w.includes = includes
+
+	return nil
+}
+
+// isConfigEvent returns true if an event is for a file that is part of
+// the config named name
+func (w *configWatch) isConfigEvent(ev inotifyEvent, name string) bool {
+	if ev.wd == w.dirWd && (ev.name == name || ev.name == configMapDataLink) {
+		return true
+	}
+	if ev.wd == w.dropInWd && slices.Contains(configExtensions, strings.ToLower(filepath.Ext(ev.name))) {
+		return true
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, pattern := range w.includes[ev.wd] {
+		if ok, _ := filepath.Match(filepath.Base(pattern), ev.name); ok || ev.name == configMapDataLink {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigWatcher notifies changes when the config file at path, the
+// Kubernetes ConfigMap containing it, a config in its drop-in directory,
+// or a file matching its include globs may have changed. Changes are not coalesced, a burst of
+// writes results in a burst of notifications.
+func ConfigWatcher(ctx context.Context, wg *sync.WaitGroup, w *configWatch, path string, logger *logging.InternalLogger, changes chan<- struct{}) {
+	wg.Add(1)
+	defer wg.Done()
+
+	go func() {
+		<-ctx.Done()
+		w.Close()
+	}()
+
+	name := filepath.Base(path)
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.Read(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Logf("Error watching config: %s", err)
+			}
+			return
+		}
+
+		for _, ev := range inotifyEvents(buf[:n]) {
+			if !w.isConfigEvent(ev, name) {
+				continue
+			}
+			select {
+			case changes <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			break
+		}
+	}
+}
+
+type inotifyEvent struct {
+	wd   int32
+	name string
+}
+
+// inotifyEvents returns the watch descriptors and file names from a
+// buffer of inotify events
+func inotifyEvents(buf []byte) []inotifyEvent {
+	var events []inotifyEvent
+	for len(buf) >= unix.SizeofInotifyEvent {
+		ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		end := unix.SizeofInotifyEvent + int(ev.Len)
+		if end > len(buf) {
+			break
+		}
+
+		// Names are padded with NUL bytes
+		name := buf[unix.SizeofInotifyEvent:end]
+		for len(name) > 0 && name[len(name)-1] == 0 {
+			name = name[:len(name)-1]
+		}
+		events = append(events, inotifyEvent{wd: ev.Wd, name: string(name)})
+
+		buf = buf[end:]
+	}
+	return events
+}
@@ -0,0 +1,80 @@
+package supervise
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"code.crute.us/mcrute/simplevisor/supervise/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigWatcher(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "simplevisor.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{}`), 0600))
+
+	w, err := watchConfig(path)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	changes := make(chan struct{}, 10)
+	logger := &logging.InternalLogger{Logs: make(chan *logging.LogRecord, 100), Pool: logging.NewBufferPool()}
+	go ConfigWatcher(ctx, wg, w, path, logger, changes)
+
+	// Other files in the directory are ignored
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "other.json"), nil, 0600))
+	assert.Never(t, func() bool { return len(changes) > 0 }, 200*time.Millisecond, 10*time.Millisecond)
+
+	// Replacing the file is noticed
+	tmp := filepath.Join(dir, "simplevisor.json.tmp")
+	assert.NoError(t, os.WriteFile(tmp, []byte(`{"jobs": {}}`), 0600))
+	assert.NoError(t, os.Rename(tmp, path))
+	assert.Eventually(t, func() bool { return len(changes) > 0 }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	wg.Wait()
+}
+
+func TestConfigWatcherIncludes(t *testing.T) {
+	dir, shared, other := t.TempDir(), t.TempDir(), t.TempDir()
+	path := filepath.Join(dir, "simplevisor.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{}`), 0600))
+
+	w, err := watchConfig(path)
+	assert.NoError(t, err)
+	assert.NoError(t, w.watchIncludes(path, []string{filepath.Join(shared, "*.yaml"), filepath.Join(other, "jobs.json")}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	changes := make(chan struct{}, 10)
+	logger := &logging.InternalLogger{Logs: make(chan *logging.LogRecord, 100), Pool: logging.NewBufferPool()}
+	go ConfigWatcher(ctx, wg, w, path, logger, changes)
+
+	// Files that do not match the globs are ignored
+	assert.NoError(t, os.WriteFile(filepath.Join(shared, "notes.txt"), nil, 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(other, "other.json"), nil, 0600))
+	assert.Never(t, func() bool { return len(changes) > 0 }, 200*time.Millisecond, 10*time.Millisecond)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(shared, "web.yaml"), nil, 0600))
+	assert.Eventually(t, func() bool { return len(changes) > 0 }, time.Second, 10*time.Millisecond)
+
+	// Directories of includes that were removed are no longer watched
+	assert.NoError(t, w.watchIncludes(path, []string{filepath.Join(other, "jobs.json")}))
+	time.Sleep(50 * time.Millisecond)
+	for len(changes) > 0 {
+		<-changes
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(shared, "web.yaml"), nil, 0600))
+	assert.Never(t, func() bool { return len(changes) > 0 }, 200*time.Millisecond, 10*time.Millisecond)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(other, "jobs.json"), nil, 0600))
+	assert.Eventually(t, func() bool { return len(changes) > 0 }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,150 @@
+package supervise
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"code.crute.us/mcrute/simplevisor/supervise/logging"
+)
+
+// Commands accepted on the control socket
+const (
+	ControlReload = "reload"
+)
+
+// How long a control socket client has to send a command
+const controlReadTimeout = 5 * time.Second
+
+// controlRequest is a command received on the control socket. The result
+// of the command is sent on result.
+type controlRequest struct {
+	command string
+	result  chan error
+}
+
+// listenControl listens on a unix socket at path, replacing any socket
+// left behind by a previous supervisor. The socket is only accessible to
+// the user running the supervisor.
+func listenControl(path string) (*net.UnixListener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("listenControl: %s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("listenControl: unable to remove old socket: %w", err)
+		}
+	}
+
+	// The socket is created with its final mode, changing it after bind
+	// would let anyone connect in between. The umask is process wide but
+	// this runs at startup before any main jobs are started.
+	oldMask := syscall.Umask(0177)
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	syscall.Umask(oldMask)
+	if err != nil {
+		return nil, fmt.Errorf("listenControl: %w", err)
+	}
+
+	return l, nil
+}
+
+// ControlServer accepts commands on the control socket and sends them to
+// the supervisor. Each connection sends a single command on one line and
+// receives either ok or error: followed by the error.
+func ControlServer(ctx context.Context, wg *sync.WaitGroup, l *net.UnixListener, logger *logging.InternalLogger, requests chan<- *controlRequest) {
+	wg.Add(1)
+	defer wg.Done()
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Logf("Error accepting control connection: %s", err)
+			}
+			return
+		}
+		handleControlConn(ctx, conn, requests)
+	}
+}
+
+func handleControlConn(ctx context.Context, conn *net.UnixConn, requests chan<- *controlRequest) {
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(controlReadTimeout))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		fmt.Fprintf(conn, "error: %s\n", err)
+		return
+	}
+
+	req := &controlRequest{
+		command: strings.TrimSpace(line),
+		result:  make(chan error, 1),
+	}
+	if req.command != ControlReload {
+		fmt.Fprintf(conn, "error: unknown command %s\n", req.command)
+		return
+	}
+
+	select {
+	case requests <- req:
+	case <-ctx.Done():
+		return
+	}
+
+	select {
+	case err = <-req.result:
+	case <-ctx.Done():
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintf(conn, "error: %s\n", err)
+	} else {
+		fmt.Fprintln(conn, "ok")
+	}
+}
+
+// SendControlCommand sends a command to the supervisor listening on the
+// control socket at path and returns the error reported by the
+// supervisor, if any
+func SendControlCommand(path, command string) error {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return fmt.Errorf("SendControlCommand: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintln(conn, command); err != nil {
+		return fmt.Errorf("SendControlCommand: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("SendControlCommand: no reply from supervisor: %w", err)
+	}
+
+	reply = strings.TrimSpace(reply)
+	if msg, ok := strings.CutPrefix(reply, "error: "); ok {
+		return errors.New(msg)
+	}
+	if reply != "ok" {
+		return fmt.Errorf("SendControlCommand: invalid reply %s", reply)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package supervise
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"code.crute.us/mcrute/simplevisor/supervise/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+
+	mask := syscall.Umask(0022)
+	defer syscall.Umask(mask)
+
+	l, err := listenControl(path)
+	assert.NoError(t, err)
+
+	// The umask is restored
+	assert.Equal(t, 0022, syscall.Umask(0022))
+
+	fi, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	requests := make(chan *controlRequest)
+	logger := &logging.InternalLogger{Logs: make(chan *logging.LogRecord, 100), Pool: logging.NewBufferPool()}
+	go ControlServer(ctx, wg, l, logger, requests)
+
+	results := []error{nil, errors.New("bad config")}
+	go func() {
+		for _, res := range results {
+			req := <-requests
+			assert.Equal(t, ControlReload, req.command)
+			req.result <- res
+		}
+	}()
+
+	assert.NoError(t, SendControlCommand(path, ControlReload))
+	assert.EqualError(t, SendControlCommand(path, ControlReload), "bad config")
+	assert.EqualError(t, SendControlCommand(path, "explode"), "unknown command explode")
+
+	cancel()
+	wg.Wait()
+}
+
+func TestListenControlReplacesSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+
+	// A socket left behind by a supervisor that did not exit cleanly
+	l, err := listenControl(path)
+	assert.NoError(t, err)
+	l.SetUnlinkOnClose(false)
+	l.Close()
+
+	l, err = listenControl(path)
+	assert.NoError(t, err)
+	l.Close()
+
+	assert.NoError(t, os.WriteFile(path, nil, 0600))
+	_, err = listenControl(path)
+	assert.ErrorContains(t, err, "is not a socket")
+}
@@ -0,0 +1,200 @@
+package supervise
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// expandVariables expands ${VAR} and ${VAR:-default} references in s
+// using lookup. Undefined variables without a default expand to an empty
+// string, as in the shell. A literal $ can be written as $$.
+func expandVariables(s string, lookup func(string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	out := &strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			out.WriteByte(s[i])
+			continue
+		}
+
+		switch s[i+1] {
+		case '$':
+			out.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference in %q", s)
+			}
+
+			name, def, hasDefault := strings.Cut(s[i+2:i+end], ":-")
+			if name == "" {
+				return "", fmt.Errorf("empty variable reference in %q", s)
+			}
+
+			if v, ok := lookup(name); ok && (v != "" || !hasDefault) {
+				out.WriteString(v)
+			} else {
+				out.WriteString(def)
+			}
+			i += end
+		default:
+			out.WriteByte('$')
+		}
+	}
+
+	return out.String(), nil
+}
+
+// parseEnvFile parses a dotenv formatted file. Each line has the form
+// KEY=value optionally prefixed with export. Blank lines and lines
+// starting with # are ignored. Values may be single quoted (literal),
+// double quoted (supporting \n, \t, \" and \\ escapes), or unquoted
+// (trailing " #" comments are removed). Double quoted and unquoted values
+// have variable references expanded using env, which is updated with
+// each variable as it is parsed so later variables can refer to earlier
+// ones. Variables are returned in file order as KEY=value.
+func parseEnvFile(r io.Reader, env map[string]string) ([]string, error) {
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+
+	out := []string{}
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: expected KEY=value", lineNo)
+		}
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch {
+		case strings.HasPrefix(value, "'"):
+			if len(value) < 2 || !strings.HasSuffix(value, "'") {
+				return nil, fmt.Errorf("line %d: unterminated single quote", lineNo)
+			}
+			value = value[1 : len(value)-1]
+		case strings.HasPrefix(value, `"`):
+			if value, err = unquoteEnvValue(value); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			if value, err = expandVariables(value, lookup); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+			if value, err = expandVariables(value, lookup); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+		}
+
+		env[key] = value
+		out = append(out, key+"="+value)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func unquoteEnvValue(v string) (string, error) {
+	out := &strings.Builder{}
+	for i := 1; i < len(v); i++ {
+		switch v[i] {
+		case '"':
+			if i != len(v)-1 {
+				return "", fmt.Errorf("unexpected characters after closing quote")
+			}
+			return out.String(), nil
+		case '\\':
+			if i++; i == len(v) {
+				return "", fmt.Errorf("unterminated double quote")
+			}
+			switch v[i] {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			default:
+				out.WriteByte(v[i])
+			}
+		default:
+			out.WriteByte(v[i])
+		}
+	}
+	return "", fmt.Errorf("unterminated double quote")
+}
+
+// loadStaticVariables loads the EnvFiles, in order, and then the
+// SetVariables from the config into env. Variable references are
+// expanded using env. The names of all loaded variables are returned.
+func loadStaticVariables(c *EnvConfig, env map[string]string) ([]string, error) {
+	names := []string{}
+
+	for _, p := range c.EnvFiles {
+		fd, err := os.Open(p)
+		if err != nil {
+			return nil, fmt.Errorf("PrepareEnvironment: unable to open env-file: %w", err)
+		}
+		vars, err := parseEnvFile(fd, env)
+		fd.Close()
+		if err != nil {
+			return nil, fmt.Errorf("PrepareEnvironment: error parsing env-file %s: %w", p, err)
+		}
+		for k := range getEnvListMap(vars) {
+			names = append(names, k)
+		}
+	}
+
+	// Expand against a snapshot so that the result doesn't depend on map
+	// iteration order
+	set, err := expandVariableMap(c.SetVariables, env)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range set {
+		env[k] = v
+		names = append(names, k)
+	}
+
+	return names, nil
+}
+
+// expandVariableMap expands variable references in the values of vars
+// using env and returns the expanded copy.
+func expandVariableMap(vars map[string]string, env map[string]string) (map[string]string, error) {
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+
+	out := make(map[string]string, len(vars))
+	for k, v := range vars {
+		ev, err := expandVariables(v, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("PrepareEnvironment: error expanding variable %s: %w", k, err)
+		}
+		out[k] = ev
+	}
+
+	return out, nil
+}
@@ -0,0 +1,107 @@
+package supervise
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandVariables(t *testing.T) {
+	env := map[string]string{"FOO": "foo", "EMPTY": ""}
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+
+	for in, out := range map[string]string{
+		"plain":                    "plain",
+		"${FOO}":                   "foo",
+		"a-${FOO}-b":               "a-foo-b",
+		"${MISSING}":               "",
+		"${MISSING:-default}":      "default",
+		"${EMPTY:-default}":        "default",
+		"${FOO:-default}":          "foo",
+		"${MISSING:-a:-b}":         "a:-b",
+		"$$FOO":                    "$FOO",
+		"$FOO":                     "$FOO",
+		"cost $5":                  "cost $5",
+		"trailing $":               "trailing $",
+		"${FOO}${MISSING:-}${FOO}": "foofoo",
+	} {
+		v, err := expandVariables(in, lookup)
+		assert.NoError(t, err, in)
+		assert.Equal(t, out, v, in)
+	}
+
+	_, err := expandVariables("${FOO", lookup)
+	assert.ErrorContains(t, err, "unterminated variable reference")
+
+	_, err = expandVariables("${:-foo}", lookup)
+	assert.ErrorContains(t, err, "empty variable reference")
+}
+
+func TestParseEnvFile(t *testing.T) {
+	env := map[string]string{"HOME": "/root"}
+	vars, err := parseEnvFile(strings.NewReader(`
+# A comment
+PLAIN=value
+export EXPORTED=exported
+SPACED = spaced value # a comment
+SINGLE='${HOME} # literal'
+DOUBLE="${HOME}/dir\n\"quoted\" # not a comment"
+REFERENCE=${PLAIN}-${MISSING:-default}
+EMPTY=
+`), env)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"PLAIN=value",
+		"EXPORTED=exported",
+		"SPACED=spaced value",
+		"SINGLE=${HOME} # literal",
+		"DOUBLE=/root/dir\n\"quoted\" # not a comment",
+		"REFERENCE=value-default",
+		"EMPTY=",
+	}, vars)
+	assert.Equal(t, "value", env["PLAIN"])
+}
+
+func TestParseEnvFileErrors(t *testing.T) {
+	for in, msg := range map[string]string{
+		"NOVALUE":         "line 1: expected KEY=value",
+		"\n=value":        "line 2: expected KEY=value",
+		"A B=value":       "line 1: expected KEY=value",
+		"A='unterminated": "line 1: unterminated single quote",
+		`A="unterminated`: "line 1: unterminated double quote",
+		`A="a"b`:          "line 1: unexpected characters after closing quote",
+		"A=${B":           "line 1: unterminated variable reference",
+	} {
+		_, err := parseEnvFile(strings.NewReader(in), map[string]string{})
+		assert.ErrorContains(t, err, msg, in)
+	}
+}
+
+func TestLoadStaticVariables(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.env")
+	second := filepath.Join(dir, "second.env")
+	assert.NoError(t, os.WriteFile(first, []byte("A=first\nB=${HOST}\n"), 0600))
+	assert.NoError(t, os.WriteFile(second, []byte("A=second-${A}\n"), 0600))
+
+	env := map[string]string{"HOST": "host"}
+	names, err := loadStaticVariables(&EnvConfig{
+		EnvFiles:     []string{first, second},
+		SetVariables: map[string]string{"C": "${A}:${B}", "B": "set"},
+	}, env)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"A", "B", "A", "C", "B"}, names)
+	assert.Equal(t, "second-first", env["A"])
+	assert.Equal(t, "set", env["B"])
+	assert.Equal(t, "second-first:host", env["C"])
+
+	_, err = loadStaticVariables(&EnvConfig{EnvFiles: []string{filepath.Join(dir, "missing")}}, env)
+	assert.ErrorContains(t, err, "unable to open env-file")
+}
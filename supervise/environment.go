@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 	"text/template"
 
@@ -36,14 +37,16 @@ func (l *EnvList) PutSome(m map[string]string, w []string) {
 }
 
 func getEnvMap() map[string]string {
-	r := envGetter()
-	m := make(map[string]string, len(r))
+	return getEnvListMap(envGetter())
+}
 
-	for _, v := range r {
+// getEnvListMap converts a list of KEY=value strings to a map
+func getEnvListMap(l []string) map[string]string {
+	m := make(map[string]string, len(l))
+	for _, v := range l {
 		kv := strings.SplitN(v, "=", 2)
 		m[kv[0]] = kv[1]
 	}
-
 	return m
 }
 
@@ -52,7 +55,7 @@ func processTemplates(env map[string]string, vars []string, replacements map[str
 	buf := &bytes.Buffer{}
 	for _, k := range vars {
 		if v, ok := env[k]; ok {
-			if tpl, err = template.New("").Parse(v); err != nil {
+			if tpl, err = template.New("").Funcs(templateFuncs).Parse(v); err != nil {
 				return fmt.Errorf("PrepareEnvironment: template failed to parse for %s: %w", k, err)
 			}
 
@@ -67,103 +70,163 @@ func processTemplates(env map[string]string, vars []string, replacements map[str
 	return nil
 }
 
-func parseSecretId(name, id string) (string, string, string, error) {
-	parsed := strings.Split(id, ":")
-	if len(parsed) != 3 {
-		return "", "", "", fmt.Errorf("PrepareEnvironment: error parsing vault variable %s, not len(3)", name)
+// secretResolver resolves secret references in the environment. Fetched
+// secrets are cached so that every reference to the same secret, for any
+// job, observes the same value.
+type secretResolver struct {
+	sc           secrets.Client
+	dbCache      map[string]*secrets.Credential
+	secretCache  map[string]map[string]any
+	awsUserCache map[string]*secrets.AWSCredential
+}
+
+func newSecretResolver(sc secrets.Client) *secretResolver {
+	return &secretResolver{
+		sc:           sc,
+		dbCache:      map[string]*secrets.Credential{},
+		secretCache:  map[string]map[string]any{},
+		awsUserCache: map[string]*secrets.AWSCredential{},
 	}
-	return parsed[0], parsed[1], parsed[2], nil
 }
 
 func expandReplacements(ctx context.Context, sc secrets.Client, envMap map[string]string, keys []string) (map[string]string, error) {
+	return newSecretResolver(sc).expand(ctx, envMap, keys)
+}
+
+func (r *secretResolver) expand(ctx context.Context, envMap map[string]string, keys []string) (map[string]string, error) {
 	// Pare this down to just VaultReplacements for template expansion
 	replacements := make(map[string]string, len(keys))
 
-	dbCache := map[string]*secrets.Credential{}
-	secretCache := map[string]map[string]string{}
-	awsUserCache := map[string]*secrets.AWSCredential{}
+	sc := r.sc
+	dbCache, secretCache, awsUserCache := r.dbCache, r.secretCache, r.awsUserCache
 	for _, k := range keys {
 		v, ok := envMap[k]
 		if !ok {
 			continue
 		}
 
-		secretType, secretPath, jsonField, err := parseSecretId(k, v)
+		ref, err := parseSecretId(k, v)
 		if err != nil {
 			return nil, err
 		}
 
-		switch secretType {
+		var val string
+		found := true
+
+		switch ref.Type {
 		case "db":
-			cred, ok := dbCache[secretPath]
+			cred, ok := dbCache[ref.Path]
 			if !ok {
-				cred, _, err = sc.DatabaseCredential(ctx, secretPath)
+				cred, _, err = sc.DatabaseCredential(ctx, ref.Path)
 				if err != nil {
 					return nil, fmt.Errorf("PrepareEnvironment: vault error: %w", err)
 				}
-				dbCache[secretPath] = cred
+				dbCache[ref.Path] = cred
 			}
 
-			switch jsonField {
+			switch ref.Field {
 			case "Username":
-				envMap[k] = cred.Username
-				replacements[k] = cred.Username
+				val = cred.Username
 			case "Password":
-				envMap[k] = cred.Password
-				replacements[k] = cred.Password
+				val = cred.Password
 			default:
-				return nil, fmt.Errorf("PrepareEnvironment: unknown field %s for db credential", jsonField)
+				return nil, fmt.Errorf("PrepareEnvironment: unknown field %s for db credential", ref.Field)
 			}
 		case "secret":
-			s, ok := secretCache[secretPath]
+			s, ok := secretCache[ref.Path]
 			if !ok {
-				s = map[string]string{}
-				if _, err = sc.Secret(ctx, secretPath, &s); err != nil {
-					return nil, fmt.Errorf("PrepareEnvironment: vault error: %w", err)
+				s = map[string]any{}
+				if _, err = sc.Secret(ctx, ref.Path, &s); err != nil {
+					// A missing secret is missing all of its fields. It
+					// is not cached so that references without a default
+					// still report the vault error.
+					if !isSecretNotFound(err) || (!ref.HasDefault && !ref.Optional) {
+						return nil, fmt.Errorf("PrepareEnvironment: vault error: %w", err)
+					}
+					s = nil
+				} else {
+					secretCache[ref.Path] = s
 				}
-				secretCache[secretPath] = s
 			}
 
-			val, ok := s[jsonField]
-			if !ok {
-				return nil, fmt.Errorf("PrepareEnvironment: secret %s has no field %s", secretPath, jsonField)
+			var raw any
+			if raw, found = ref.lookup(s); found {
+				if val, err = renderSecretValue(raw); err != nil {
+					return nil, fmt.Errorf("PrepareEnvironment: unable to render field %s of secret %s: %w", ref.Field, ref.Path, err)
+				}
 			}
-			envMap[k] = val
-			replacements[k] = val
 		case "aws-user":
-			cred, ok := awsUserCache[secretPath]
+			cred, ok := awsUserCache[ref.Path]
 			if !ok {
-				cred, _, err = sc.AWSIAMUser(ctx, secretPath)
+				cred, _, err = sc.AWSIAMUser(ctx, ref.Path)
 				if err != nil {
 					return nil, fmt.Errorf("PrepareEnvironment: vault error: %w", err)
 				}
-				awsUserCache[secretPath] = cred
+				awsUserCache[ref.Path] = cred
 			}
 
-			switch jsonField {
+			switch ref.Field {
 			case "KeyId":
-				envMap[k] = cred.AccessKeyId
-				replacements[k] = cred.AccessKeyId
+				val = cred.AccessKeyId
 			case "SecretKey":
-				envMap[k] = cred.SecretAccessKey
-				replacements[k] = cred.SecretAccessKey
+				val = cred.SecretAccessKey
 			default:
-				return nil, fmt.Errorf("PrepareEnvironment: unknown field %s for AWS IAM user credential", jsonField)
+				return nil, fmt.Errorf("PrepareEnvironment: unknown field %s for AWS IAM user credential", ref.Field)
 			}
 		default:
-			return nil, fmt.Errorf("PrepareEnvironment: invalid secret type %s", secretType)
+			return nil, fmt.Errorf("PrepareEnvironment: invalid secret type %s", ref.Type)
+		}
+
+		if !found {
+			switch {
+			case ref.HasDefault:
+				val = ref.Default
+			case ref.Optional:
+				// Don't leak the secret reference into the subprocess
+				delete(envMap, k)
+				continue
+			default:
+				return nil, fmt.Errorf("PrepareEnvironment: secret %s has no field %s", ref.Path, ref.Field)
+			}
 		}
+
+		envMap[k] = val
+		replacements[k] = val
 	}
 
 	return replacements, nil
 }
 
 func PrepareEnvironment(ctx context.Context, c *EnvConfig, sc secrets.Client, vaultToken string) ([]string, error) {
-	var err error
+	e, err := NewJobEnvironment(ctx, c, sc, vaultToken)
+	if err != nil {
+		return nil, err
+	}
+	return e.Global, nil
+}
+
+// JobEnvironment is the prepared global environment along with the
+// state needed to derive the environment of jobs that have their own
+// environment configuration.
+type JobEnvironment struct {
+	// Global is the environment for jobs without environment config
+	Global []string
 
+	config       *EnvConfig
+	envMap       map[string]string
+	replacements map[string]string
+	resolver     *secretResolver
+}
+
+func NewJobEnvironment(ctx context.Context, c *EnvConfig, sc secrets.Client, vaultToken string) (*JobEnvironment, error) {
 	envMap := getEnvMap()
 	out := EnvList{}
 
+	staticNames, err := loadStaticVariables(c, envMap)
+	if err != nil {
+		return nil, err
+	}
+
 	// Export VAULT_TOKEN
 	if c.SetVaultToken && vaultToken != "" {
 		out.Put("VAULT_TOKEN", vaultToken)
@@ -173,28 +236,124 @@ func PrepareEnvironment(ctx context.Context, c *EnvConfig, sc secrets.Client, va
 	}
 
 	var replacements map[string]string
+	resolver := newSecretResolver(sc)
 
 	// Process vault expansions
 	if c.VaultReplacements != nil {
-		if replacements, err = expandReplacements(ctx, sc, envMap, c.VaultReplacements); err != nil {
+		if replacements, err = resolver.expand(ctx, envMap, c.VaultReplacements); err != nil {
 			return nil, err
 		}
 	}
 
 	// Process templates
-	if c.VaultTemplateVariables != nil && replacements != nil {
-		if err = processTemplates(envMap, c.VaultTemplateVariables, replacements); err != nil {
+	if c.VaultTemplateVariables != nil && (replacements != nil || c.TemplateEnvironment) {
+		tplContext := replacements
+		if c.TemplateEnvironment {
+			tplContext = templateContext(c, envMap, replacements)
+		}
+		if err = processTemplates(envMap, c.VaultTemplateVariables, tplContext); err != nil {
 			return nil, err
 		}
 	}
 
+	for _, k := range c.UnsetVariables {
+		delete(envMap, k)
+	}
+
 	// If we're passing everything then format it and return
 	if c.PassAllVariables {
 		out.PutAll(envMap)
-	} else if c.PassVariables != nil {
-		// Otherwise pass only the configured names
-		out.PutSome(envMap, c.PassVariables)
+	} else {
+		// Otherwise pass only the configured and static names
+		names := slices.Concat(c.PassVariables, staticNames)
+		slices.Sort(names)
+		out.PutSome(envMap, slices.Compact(names))
+	}
+
+	return &JobEnvironment{
+		Global:       []string(out),
+		config:       c,
+		envMap:       envMap,
+		replacements: replacements,
+		resolver:     resolver,
+	}, nil
+}
+
+// For returns the environment for a job with the job environment config
+// applied on top of the global environment. A nil config returns the
+// global environment.
+func (e *JobEnvironment) For(ctx context.Context, jc *JobEnvConfig) ([]string, error) {
+	if jc == nil {
+		return e.Global, nil
+	}
+
+	envMap := make(map[string]string, len(e.envMap))
+	for k, v := range e.envMap {
+		envMap[k] = v
+	}
+
+	replacements := make(map[string]string, len(e.replacements))
+	for k, v := range e.replacements {
+		replacements[k] = v
+	}
+
+	// Variables resolved globally are already expanded in envMap
+	keys := []string{}
+	for _, k := range jc.VaultReplacements {
+		if _, ok := replacements[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+
+	jobReplacements, err := e.resolver.expand(ctx, envMap, keys)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range jobReplacements {
+		replacements[k] = v
+	}
+
+	if jc.VaultTemplateVariables != nil {
+		tplContext := replacements
+		if e.config.TemplateEnvironment {
+			tplContext = templateContext(e.config, envMap, replacements)
+		}
+		if err = processTemplates(envMap, jc.VaultTemplateVariables, tplContext); err != nil {
+			return nil, err
+		}
+	}
+
+	vars := getEnvListMap(e.Global)
+	if jc.PassVariables != nil {
+		visible := make(map[string]string, len(jc.PassVariables))
+		for _, k := range jc.PassVariables {
+			if v, ok := vars[k]; ok {
+				visible[k] = v
+			}
+		}
+		vars = visible
+	}
+
+	// Optional secrets that were not found are removed so the reference
+	// does not leak through a globally passed variable
+	for _, k := range slices.Concat(jc.VaultReplacements, jc.VaultTemplateVariables) {
+		if v, ok := envMap[k]; ok {
+			vars[k] = v
+		} else {
+			delete(vars, k)
+		}
+	}
+
+	set, err := expandVariableMap(jc.SetVariables, envMap)
+	if err != nil {
+		return nil, err
 	}
+	for k, v := range set {
+		vars[k] = v
+	}
+
+	out := EnvList{}
+	out.PutAll(vars)
 
 	return []string(out), nil
 }
@@ -63,14 +63,21 @@ func TestGetEnvMap(t *testing.T) {
 }
 
 func TestParseSecretId(t *testing.T) {
-	_, _, _, err := parseSecretId("name", "foo:bar")
+	_, err := parseSecretId("name", "foo:bar")
 	assert.ErrorContains(t, err, "error parsing vault variable name")
 
-	a, b, c, err := parseSecretId("foo", "db:path:key")
+	r, err := parseSecretId("foo", "db:path:key")
 	assert.NoError(t, err)
-	assert.Equal(t, "db", a)
-	assert.Equal(t, "path", b)
-	assert.Equal(t, "key", c)
+	assert.Equal(t, "db", r.Type)
+	assert.Equal(t, "path", r.Path)
+	assert.Equal(t, "key", r.Field)
+
+	r, err = parseSecretId("foo", "secret:path:with:colons:key.with.dots")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", r.Type)
+	assert.Equal(t, "path:with:colons", r.Path)
+	assert.Equal(t, "key.with.dots", r.Field)
+	assert.Equal(t, []fieldPart{{Key: "key.with.dots"}}, r.fieldPath)
 }
 
 type ProcessTemplatesSuite struct {
@@ -133,13 +140,24 @@ func (c *MockSecretClient) Secret(ctx context.Context, path string, out any) (se
 	if c.doError {
 		return nil, fmt.Errorf("an error")
 	}
-	o := out.(*map[string]string)
+	o := out.(*map[string]any)
 	switch path {
+	case "missing":
+		return nil, fmt.Errorf("error reading secret: Error making API request.\n\nURL: GET https://vault/v1/missing\nCode: 404. Errors:\n\n")
+	case "forbidden":
+		return nil, fmt.Errorf("error reading secret: Error making API request.\n\nURL: GET https://vault/v1/forbidden\nCode: 403. Errors:\n\n* permission denied")
 	case "path":
 		(*o)["foo"] = "bar"
 		(*o)["baz"] = "buz"
 	case "path2":
 		(*o)["biz"] = "buz"
+	case "nested":
+		(*o)["db"] = map[string]any{
+			"hosts": []any{"db1", "db2"},
+			"port":  float64(5432),
+			"tls":   true,
+			"null":  nil,
+		}
 	}
 	return nil, nil
 }
@@ -188,6 +206,14 @@ func (s *ExpandReplacementsSuite) SetupTest() {
 		"AWS_IAM_INVALID":      "aws-user:path:invalid",
 		"AWS_IAM_2_KEYID":      "aws-user:path2:KeyId",
 		"INVALID_TYPE":         "foo:bar:baz",
+		"NESTED_HOST":          "vault+kv://nested#db.hosts[1]",
+		"NESTED_PORT":          "vault+kv://nested#db.port",
+		"NESTED_TLS":           "vault+kv://nested#db.tls",
+		"NESTED_OBJECT":        "vault+kv://nested#db.hosts",
+		"NESTED_NULL":          "vault+kv://nested#db.null",
+		"NESTED_DEFAULT":       "vault+kv://nested?default=fallback#db.missing",
+		"NESTED_OPTIONAL":      "vault+kv://nested?optional#db.missing",
+		"URI_DB_USERNAME":      "vault+db://path#Username",
 	}
 	s.sc = &MockSecretClient{}
 	s.ctx = context.TODO()
@@ -243,6 +269,47 @@ func (s *ExpandReplacementsSuite) TestSecretCache() {
 	assert.Equal(s.T(), 1, s.sc.secretCalls)
 }
 
+func (s *ExpandReplacementsSuite) TestSecretNested() {
+	r, err := expandReplacements(s.ctx, s.sc, s.env, []string{"NESTED_HOST", "NESTED_PORT", "NESTED_TLS", "NESTED_OBJECT"})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), map[string]string{
+		"NESTED_HOST":   "db2",
+		"NESTED_PORT":   "5432",
+		"NESTED_TLS":    "true",
+		"NESTED_OBJECT": `["db1","db2"]`,
+	}, r)
+	assert.Equal(s.T(), 1, s.sc.secretCalls)
+}
+
+func (s *ExpandReplacementsSuite) TestSecretNull() {
+	_, err := expandReplacements(s.ctx, s.sc, s.env, []string{"NESTED_NULL"})
+	assert.ErrorContains(s.T(), err, "secret nested has no field db.null")
+}
+
+func (s *ExpandReplacementsSuite) TestSecretDefault() {
+	r, err := expandReplacements(s.ctx, s.sc, s.env, []string{"NESTED_DEFAULT"})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), map[string]string{"NESTED_DEFAULT": "fallback"}, r)
+	assert.Equal(s.T(), "fallback", s.env["NESTED_DEFAULT"])
+}
+
+func (s *ExpandReplacementsSuite) TestSecretOptional() {
+	r, err := expandReplacements(s.ctx, s.sc, s.env, []string{"NESTED_OPTIONAL"})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), map[string]string{}, r)
+	assert.NotContains(s.T(), s.env, "NESTED_OPTIONAL")
+}
+
+func (s *ExpandReplacementsSuite) TestDbURI() {
+	r, err := expandReplacements(s.ctx, s.sc, s.env, []string{"URI_DB_USERNAME", "DB_SECRET_PASS"})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), map[string]string{
+		"URI_DB_USERNAME": "user1",
+		"DB_SECRET_PASS":  "pass1",
+	}, r)
+	assert.Equal(s.T(), 1, s.sc.dbCalls)
+}
+
 func (s *ExpandReplacementsSuite) TestIamUser() {
 	r, err := expandReplacements(s.ctx, s.sc, s.env, []string{"AWS_IAM_KEYID", "AWS_IAM_2_KEYID"})
 	assert.NoError(s.T(), err)
@@ -347,6 +414,130 @@ func (s *PrepareEnvironmentSuite) TestPassSome() {
 	assert.NotContains(s.T(), r, "BUZ=bap")
 }
 
+func (s *PrepareEnvironmentSuite) TestSetAndUnset() {
+	r, err := PrepareEnvironment(s.ctx, &EnvConfig{
+		PassVariables:  []string{"FOO", "BIZ", "STATIC"},
+		SetVariables:   map[string]string{"STATIC": "${FOO}-${NOPE:-default}", "OTHER": "other"},
+		UnsetVariables: []string{"BIZ"},
+	}, s.sc, "")
+	assert.NoError(s.T(), err)
+	assert.ElementsMatch(s.T(), []string{"FOO=bar", "STATIC=bar-default", "OTHER=other"}, r)
+
+	r, err = PrepareEnvironment(s.ctx, &EnvConfig{
+		PassAllVariables: true,
+		UnsetVariables:   []string{"BIZ"},
+	}, s.sc, "")
+	assert.NoError(s.T(), err)
+	assert.ElementsMatch(s.T(), []string{"FOO=bar", "BUZ=bap"}, r)
+}
+
+func (s *PrepareEnvironmentSuite) TestTemplateEnvironment() {
+	envGetter = func() []string {
+		return []string{
+			"HOST=db.example.com",
+			"URL={{ .HOST }}:{{ .PORT | default \"5432\" }}",
+		}
+	}
+
+	r, err := PrepareEnvironment(s.ctx, &EnvConfig{
+		PassVariables:          []string{"HOST", "URL"},
+		VaultTemplateVariables: []string{"URL"},
+		TemplateEnvironment:    true,
+	}, s.sc, "")
+	assert.NoError(s.T(), err)
+	assert.Contains(s.T(), r, "URL=db.example.com:5432")
+
+	r, err = PrepareEnvironment(s.ctx, &EnvConfig{
+		PassVariables:          []string{"HOST", "URL"},
+		VaultTemplateVariables: []string{"URL"},
+	}, s.sc, "")
+	assert.NoError(s.T(), err)
+	assert.Contains(s.T(), r, "URL={{ .HOST }}:{{ .PORT | default \"5432\" }}")
+}
+
+func (s *PrepareEnvironmentSuite) TestJobEnvironment() {
+	envGetter = func() []string {
+		return []string{
+			"HOST=db.example.com",
+			"GLOBAL_USER=db:path:Username",
+			"DB_USER=db:path:Username",
+			"DB_PASS=db:path:Password",
+			"DB_URL={{ .DB_USER }}@{{ .HOST }}",
+			"AWS_KEY=aws-user:path:KeyId",
+			"MISSING=vault+kv://path?optional#nope",
+		}
+	}
+
+	e, err := NewJobEnvironment(s.ctx, &EnvConfig{
+		PassAllVariables:    true,
+		VaultReplacements:   []string{"GLOBAL_USER"},
+		TemplateEnvironment: true,
+	}, s.sc, "")
+	assert.NoError(s.T(), err)
+	assert.Contains(s.T(), e.Global, "GLOBAL_USER=user1")
+	assert.Contains(s.T(), e.Global, "DB_PASS=db:path:Password")
+
+	r, err := e.For(s.ctx, nil)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), e.Global, r)
+
+	r, err = e.For(s.ctx, &JobEnvConfig{
+		PassVariables:          []string{"HOST", "GLOBAL_USER"},
+		SetVariables:           map[string]string{"STATIC": "value", "HOST": "override"},
+		VaultReplacements:      []string{"DB_USER", "DB_PASS", "GLOBAL_USER", "MISSING"},
+		VaultTemplateVariables: []string{"DB_URL"},
+	})
+	assert.NoError(s.T(), err)
+	assert.ElementsMatch(s.T(), []string{
+		"HOST=override",
+		"GLOBAL_USER=user1",
+		"DB_USER=user1",
+		"DB_PASS=pass1",
+		"DB_URL=user1@db.example.com",
+		"STATIC=value",
+	}, r)
+
+	// Secrets are shared between the global and job environments
+	assert.Equal(s.T(), 1, s.sc.dbCalls)
+
+	r, err = e.For(s.ctx, &JobEnvConfig{
+		PassVariables:     []string{"HOST"},
+		VaultReplacements: []string{"AWS_KEY"},
+	})
+	assert.NoError(s.T(), err)
+	assert.ElementsMatch(s.T(), []string{"HOST=db.example.com", "AWS_KEY=key1"}, r)
+
+	// Job resolution does not modify the global environment
+	assert.Contains(s.T(), e.Global, "DB_PASS=db:path:Password")
+	assert.Contains(s.T(), e.Global, "MISSING=vault+kv://path?optional#nope")
+
+	r, err = e.For(s.ctx, &JobEnvConfig{VaultReplacements: []string{"MISSING"}})
+	assert.NoError(s.T(), err)
+	assert.NotContains(s.T(), r, "MISSING=vault+kv://path?optional#nope")
+}
+
+func (s *PrepareEnvironmentSuite) TestMissingSecret() {
+	envGetter = func() []string {
+		return []string{
+			"DEBUG=vault+kv://missing?default=false#debug",
+			"OPTIONAL=vault+kv://missing?optional#key",
+		}
+	}
+
+	r, err := PrepareEnvironment(s.ctx, &EnvConfig{
+		PassAllVariables:  true,
+		VaultReplacements: []string{"DEBUG", "OPTIONAL"},
+	}, s.sc, "")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{"DEBUG=false"}, r)
+
+	for _, id := range []string{"vault+kv://missing#key", "secret:missing:key", "vault+kv://forbidden?optional#key"} {
+		envGetter = func() []string { return []string{"SECRET=" + id} }
+		_, err = PrepareEnvironment(s.ctx, &EnvConfig{VaultReplacements: []string{"SECRET"}}, s.sc, "")
+		assert.ErrorContains(s.T(), err, "PrepareEnvironment: vault error", id)
+	}
+}
+
 func TestPrepareEnvironmentSuite(t *testing.T) {
 	suite.Run(t, &PrepareEnvironmentSuite{})
 }
@@ -0,0 +1,149 @@
+package supervise
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Options for tmpfs mounts that do not specify any
+const defaultTmpfsOptions = "mode=1777"
+
+// FilesystemConfig restricts the view of the filesystem for a job. Paths
+// are within Chroot, if it is set. All options other than Chroot
+// require, and imply, a mount namespace. It is embedded in Command so the
+// options are configured directly on the job.
+type FilesystemConfig struct {
+	// Chroot is the root directory of the job.
+	Chroot string `json:"chroot"`
+
+	// ReadOnlyPaths are made read-only for the job, including any mounts
+	// beneath them.
+	ReadOnlyPaths []string `json:"read-only-paths"`
+
+	// InaccessiblePaths are files and directories that can not be
+	// accessed by the job. Directories are replaced with an empty,
+	// read-only directory and files can not be opened.
+	InaccessiblePaths []string `json:"inaccessible-paths"`
+
+	// Tmpfs are paths where an empty tmpfs is mounted. Mount options may
+	// follow the path after a colon, for example /tmp:size=64m,mode=1777.
+	// The default options are mode=1777.
+	Tmpfs []string `json:"tmpfs"`
+}
+
+func (c *FilesystemConfig) validate() error {
+	if c.Chroot != "" && !filepath.IsAbs(c.Chroot) {
+		return fmt.Errorf("chroot %s must be an absolute path", c.Chroot)
+	}
+
+	for _, p := range slices.Concat(c.ReadOnlyPaths, c.InaccessiblePaths, c.tmpfsPaths()) {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("path %s must be absolute", p)
+		}
+	}
+
+	return nil
+}
+
+// needsMountNamespace reports whether the config mounts anything, which
+// must be done in a private mount namespace
+func (c *FilesystemConfig) needsMountNamespace() bool {
+	return len(c.ReadOnlyPaths) > 0 || len(c.InaccessiblePaths) > 0 || len(c.Tmpfs) > 0
+}
+
+func (c *FilesystemConfig) tmpfsPaths() []string {
+	out := make([]string, 0, len(c.Tmpfs))
+	for _, t := range c.Tmpfs {
+		p, _ := parseTmpfs(t)
+		out = append(out, p)
+	}
+	return out
+}
+
+func parseTmpfs(spec string) (string, string) {
+	path, options, ok := strings.Cut(spec, ":")
+	if !ok {
+		options = defaultTmpfsOptions
+	}
+	return path, options
+}
+
+// setupFilesystem applies the config in the mount namespace of the child
+// and then changes the root directory. Read-only paths are applied first
+// so that tmpfs mounts within them remain writable. This must be called
+// before dropping privileges.
+func setupFilesystem(c *FilesystemConfig) (string, error) {
+	for _, p := range c.ReadOnlyPaths {
+		p = filepath.Join(c.Chroot, p)
+		if err := bindReadOnly(p); err != nil {
+			return "read-only " + p, err
+		}
+	}
+
+	for _, p := range c.InaccessiblePaths {
+		p = filepath.Join(c.Chroot, p)
+		if err := makeInaccessible(p); err != nil {
+			return "inaccessible " + p, err
+		}
+	}
+
+	for _, t := range c.Tmpfs {
+		p, options := parseTmpfs(t)
+		p = filepath.Join(c.Chroot, p)
+		if err := unix.Mount("tmpfs", p, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, options); err != nil {
+			return "tmpfs " + p, err
+		}
+	}
+
+	if c.Chroot != "" {
+		if err := unix.Chroot(c.Chroot); err != nil {
+			return "chroot " + c.Chroot, err
+		}
+		if err := unix.Chdir("/"); err != nil {
+			return "chroot " + c.Chroot, err
+		}
+	}
+
+	return "", nil
+}
+
+func bindReadOnly(p string) error {
+	if err := unix.Mount(p, p, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return err
+	}
+
+	// mount_setattr is only available on Linux 5.12 and later, before
+	// that only the top mount can be made read-only
+	err := unix.MountSetattr(unix.AT_FDCWD, p, unix.AT_RECURSIVE, &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY})
+	if errors.Is(err, unix.ENOSYS) {
+		err = unix.Mount("", p, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, "")
+	}
+
+	return err
+}
+
+// makeInaccessible mounts an empty, read-only directory over p if it is
+// a directory. Files are replaced with /dev/null on a nodev mount, which
+// can not be opened.
+func makeInaccessible(p string) error {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		return unix.Mount("tmpfs", p, "tmpfs", unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "mode=000")
+	}
+
+	if err := unix.Mount("/dev/null", p, "", unix.MS_BIND, ""); err != nil {
+		return err
+	}
+
+	return unix.Mount("", p, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+}
@@ -0,0 +1,58 @@
+package supervise
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTmpfs(t *testing.T) {
+	p, options := parseTmpfs("/tmp")
+	assert.Equal(t, "/tmp", p)
+	assert.Equal(t, "mode=1777", options)
+
+	p, options = parseTmpfs("/run/app:size=16m,mode=0755")
+	assert.Equal(t, "/run/app", p)
+	assert.Equal(t, "size=16m,mode=0755", options)
+}
+
+func TestFilesystemConfigValidate(t *testing.T) {
+	c := &FilesystemConfig{
+		Chroot:            "/srv/root",
+		ReadOnlyPaths:     []string{"/"},
+		InaccessiblePaths: []string{"/etc/secrets"},
+		Tmpfs:             []string{"/tmp:size=8m"},
+	}
+	assert.NoError(t, c.validate())
+	assert.True(t, c.needsMountNamespace())
+
+	assert.False(t, (&FilesystemConfig{Chroot: "/srv/root"}).needsMountNamespace())
+
+	for msg, c := range map[string]*FilesystemConfig{
+		"chroot srv must be an absolute path": {Chroot: "srv"},
+		"path etc must be absolute":           {ReadOnlyPaths: []string{"etc"}},
+		"path secrets must be absolute":       {InaccessiblePaths: []string{"secrets"}},
+		"path tmp must be absolute":           {Tmpfs: []string{"tmp:mode=0700"}},
+	} {
+		assert.ErrorContains(t, c.validate(), msg)
+	}
+}
+
+func TestCommandUnmarshalFilesystem(t *testing.T) {
+	c := &Command{}
+	assert.NoError(t, json.Unmarshal([]byte(`{
+		"chroot": "/srv/root",
+		"read-only-paths": ["/"],
+		"inaccessible-paths": ["/etc/secrets"],
+		"tmpfs": ["/tmp"]
+	}`), c))
+	assert.Equal(t, FilesystemConfig{
+		Chroot:            "/srv/root",
+		ReadOnlyPaths:     []string{"/"},
+		InaccessiblePaths: []string{"/etc/secrets"},
+		Tmpfs:             []string{"/tmp"},
+	}, c.FilesystemConfig)
+
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"tmpfs": ["tmp"]}`), &Command{}), "path tmp must be absolute")
+}
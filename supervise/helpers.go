@@ -3,7 +3,10 @@ package supervise
 import (
 	"os"
 	"os/user"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 )
 
 func mustPipe() (*os.File, *os.File) {
@@ -14,6 +17,16 @@ func mustPipe() (*os.File, *os.File) {
 	return r, w
 }
 
+// mustSocketPair returns a connected pair of unix sockets, used where
+// the parent and child need to talk in both directions
+func mustSocketPair() (*os.File, *os.File) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		panic(err)
+	}
+	return os.NewFile(uintptr(fds[0]), "socketpair"), os.NewFile(uintptr(fds[1]), "socketpair")
+}
+
 func getUid(name string) (int, error) {
 	u, err := user.Lookup(name)
 	if err != nil {
@@ -37,3 +50,57 @@ func getGid(name string) (int, error) {
 	}
 	return gid, nil
 }
+
+func getHomeDir(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.HomeDir, nil
+}
+
+// expandHome expands a leading ~ in path to home
+func expandHome(path, home string) string {
+	if path == "~" {
+		return home
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return filepath.Join(home, rest)
+	}
+	return path
+}
+
+// getGroups returns the ids of the groups to set as supplementary groups
+// for a job. If groups is empty the groups that the user is a member of
+// are used.
+func getGroups(userName string, groups []string) ([]int, error) {
+	if len(groups) == 0 {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return nil, err
+		}
+		if groups, err = u.GroupIds(); err != nil {
+			return nil, err
+		}
+
+		out := make([]int, 0, len(groups))
+		for _, g := range groups {
+			gid, err := strconv.Atoi(g)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, gid)
+		}
+		return out, nil
+	}
+
+	out := make([]int, 0, len(groups))
+	for _, g := range groups {
+		gid, err := getGid(g)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, gid)
+	}
+	return out, nil
+}
@@ -0,0 +1,31 @@
+package supervise
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetGroups(t *testing.T) {
+	g, err := getGroups("root", nil)
+	assert.NoError(t, err)
+	assert.Contains(t, g, 0)
+
+	g, err = getGroups("root", []string{"root"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0}, g)
+
+	_, err = getGroups("root", []string{"root", "simplevisor-no-such-group"})
+	assert.ErrorContains(t, err, "simplevisor-no-such-group")
+
+	_, err = getGroups("simplevisor-no-such-user", nil)
+	assert.ErrorContains(t, err, "simplevisor-no-such-user")
+}
+
+func TestExpandHome(t *testing.T) {
+	assert.Equal(t, "/home/foo", expandHome("~", "/home/foo"))
+	assert.Equal(t, "/home/foo/bar", expandHome("~/bar", "/home/foo"))
+	assert.Equal(t, "/srv/~", expandHome("/srv/~", "/home/foo"))
+	assert.Equal(t, "~bar", expandHome("~bar", "/home/foo"))
+	assert.Equal(t, "", expandHome("", "/home/foo"))
+}
@@ -4,24 +4,49 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"code.crute.us/mcrute/golib/secrets"
 	"code.crute.us/mcrute/simplevisor/supervise/logging"
 )
 
-func SecretsLogger(ctx context.Context, wg *sync.WaitGroup, sc secrets.ClientManager, logger *logging.InternalLogger, failures chan error) {
+// SecretsLogger logs secret renewals and reports critical renewal
+// failures. If grace is non-zero a failure is only reported once
+// renewals have been failing for longer than grace without an
+// intervening successful renewal.
+func SecretsLogger(ctx context.Context, wg *sync.WaitGroup, sc secrets.ClientManager, logger *logging.InternalLogger, failures chan error, grace time.Duration) {
 	wg.Add(1)
 	defer wg.Done()
 
+	var lastErr error
+	var graceExpired <-chan time.Time
+
 	notifications := sc.Notifications()
 	for {
 		select {
 		case n := <-notifications:
 			if n.Critical && n.Error != nil {
-				failures <- fmt.Errorf("Error in renewing secrets: %w", n.Error)
+				if grace == 0 {
+					failures <- fmt.Errorf("Error in renewing secrets: %w", n.Error)
+					continue
+				}
+				if graceExpired == nil {
+					logger.Logf("Error in renewing secrets, will terminate in %s if not resolved: %s", grace, n.Error)
+					graceExpired = time.After(grace)
+				} else {
+					logger.Logf("Error in renewing secrets: %s", n.Error)
+				}
+				lastErr = n.Error
 			} else {
+				if graceExpired != nil {
+					logger.Logf("Secret renewal recovered")
+					graceExpired, lastErr = nil, nil
+				}
 				logger.Logf("Credential %s renewed at %s", n.Name, n.Time)
 			}
+		case <-graceExpired:
+			graceExpired = nil
+			failures <- fmt.Errorf("Error in renewing secrets, grace period of %s expired: %w", grace, lastErr)
 		case <-ctx.Done():
 			return
 		}
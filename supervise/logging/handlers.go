@@ -27,6 +27,24 @@ func StdoutWriter(ctx context.Context, wg *sync.WaitGroup, stdout io.Writer, log
 	}
 }
 
+// FlushLogs writes any logs that are still queued, for example when
+// StdoutWriter has stopped or was never scheduled. Each log is written
+// once even if StdoutWriter is still running, but the order of logs is
+// then not guaranteed.
+func FlushLogs(stdout io.Writer, logger *InternalLogger) {
+	writer := json.NewEncoder(stdout)
+
+	for {
+		select {
+		case r := <-logger.Logs:
+			writer.Encode(r)
+			logger.Pool.Put(r)
+		default:
+			return
+		}
+	}
+}
+
 func ProcessLogHandler(ctx context.Context, wg *sync.WaitGroup, logger *InternalLogger, rawStream io.Reader, name string, streamType StreamType) {
 	wg.Add(1)
 	defer wg.Done()
@@ -2,34 +2,160 @@ package supervise
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 //go:generate go run ../generate_syscall/main.go
 
 type AppConfig struct {
-	Environment *EnvConfig  `json:"env"`
-	Jobs        *JobsConfig `json:"jobs"`
+	// Schema is the JSON Schema of the config, which is used by editors
+	// and ignored by the supervisor. See --mode=schema.
+	Schema string `json:"$schema"`
+
+	// Include are globs of config files that are merged into this config,
+	// before the files in the drop-in directory of this config. Relative
+	// globs are relative to this config. Only the main config may include
+	// other configs.
+	Include []string `json:"include"`
+
+	// Environment configures the environment of every job.
+	Environment *EnvConfig `json:"env"`
+
+	// Jobs are the init and main jobs run by the supervisor.
+	Jobs *JobsConfig `json:"jobs"`
+
+	// Vault configures the Vault client used to resolve secrets.
+	Vault *VaultConfig `json:"vault"`
+
+	// Signals configures the action the supervisor takes for each signal
+	// it receives. Signals that are not configured use the defaults, TERM
+	// and INT shut down the supervisor, HUP reloads the config, CHLD,
+	// WINCH, and URG are ignored, and all others are forwarded to every
+	// job.
+	Signals SignalConfig `json:"signals"`
 }
 
+// ReadAppConfig reads and validates the config at path. Configs ending in
+// .yaml, .yml, or .toml are YAML or TOML, all others are JSON. The
+// configs matched by the include globs of the config and then those in
+// its drop-in directory are merged into it, see mergeConfig. Job defaults
+// and templates are then applied to jobs, see resolveJobTemplates.
+// Unknown fields are errors so that typos are not silently ignored. All
+// errors found are returned.
 func ReadAppConfig(path string) (*AppConfig, error) {
-	cf, err := os.ReadFile(path)
+	raw, errs, invalid, err := readRawConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("readConfig: %w", err)
+	}
+
+	var include []string
+	if patterns, ok := raw["include"].([]any); ok {
+		for _, p := range patterns {
+			if s, ok := p.(string); ok {
+				include = append(include, s)
+			}
+		}
+	}
+
+	files, err := configIncludes(path, include)
+	if err != nil {
+		return nil, fmt.Errorf("readConfig: %w", err)
+	}
+
+	for _, f := range files {
+		fraw, funknown, finvalid, err := readRawConfig(f)
+		if err != nil {
+			return nil, fmt.Errorf("readConfig: %w", err)
+		}
+		if _, ok := fraw["include"]; ok {
+			funknown = append(funknown, fmt.Errorf("include: only allowed in the main config"))
+		}
+		for _, e := range funknown {
+			errs = append(errs, fmt.Errorf("%s: %w", f, e))
+		}
+		for _, e := range finvalid {
+			invalid = append(invalid, fmt.Errorf("%s: %w", f, e))
+		}
+		mergeConfig(raw, fraw)
+	}
+
+	invalid = append(invalid, resolveJobTemplates(raw)...)
+
+	removeDisabledJobs(raw)
+
+	cf, err := json.Marshal(raw)
 	if err != nil {
-		return nil, fmt.Errorf("readConfig: unable to load config")
+		return nil, fmt.Errorf("readConfig: %w", err)
 	}
 
 	cfg := &AppConfig{}
-	if err := json.Unmarshal(cf, &cfg); err != nil {
-		return nil, fmt.Errorf("readConfig: unable to parse config: %s", err)
+	if err := json.Unmarshal(cf, &cfg); err != nil || len(invalid) > 0 {
+		// Errors with a path are more useful, decoding stops at the first
+		// error anyway
+		if len(invalid) == 0 {
+			invalid = []error{err}
+		}
+		errs = append(errs, invalid...)
+		return nil, fmt.Errorf("readConfig: invalid config:\n%w", errors.Join(errs...))
+	}
+
+	if cfg.Environment == nil {
+		cfg.Environment = &EnvConfig{}
+	}
+	if cfg.Jobs == nil {
+		cfg.Jobs = &JobsConfig{}
+	}
+
+	if errs = append(errs, cfg.validate()...); len(errs) > 0 {
+		return nil, fmt.Errorf("readConfig: invalid config:\n%w", errors.Join(errs...))
 	}
 
 	return cfg, nil
 }
 
+// readRawConfig reads a config file into a generic JSON object with
+// relative paths resolved. Unknown fields and invalid values in the file
+// are returned as errors, see configErrors.
+func readRawConfig(path string) (raw map[string]any, unknown, invalid []error, err error) {
+	cf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to load config: %w", err)
+	}
+
+	if cf, err = configToJSON(path, cf); err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to parse config %s: %s", path, err)
+	}
+
+	v, err := parseRawConfig(cf)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to parse config %s: %s", path, err)
+	}
+
+	// An empty YAML or TOML file is an empty config
+	switch v := v.(type) {
+	case nil:
+		raw = map[string]any{}
+	case map[string]any:
+		raw = v
+	default:
+		return nil, nil, nil, fmt.Errorf("config %s must be an object", path)
+	}
+
+	unknown, invalid = configErrors(raw, reflect.TypeFor[AppConfig](), "")
+	resolveConfigPaths(raw, filepath.Dir(path))
+
+	return raw, unknown, invalid, nil
+}
+
 type JobsConfig struct {
 	// Init jobs are a list of jobs that will be run serially before the
 	// main jobs start. Any non-zero return code from these jobs will
@@ -42,6 +168,16 @@ type JobsConfig struct {
 	// backoff for up to 5 minutes before the supervsior marks them as
 	// failed and terminates all jobs.
 	Main []*Command `json:"main"`
+
+	// Defaults are job fields that apply to every init and main job
+	// unless the job, or a template that it extends, sets them. They are
+	// applied to the jobs when the config is read.
+	Defaults *commandConfig `json:"defaults"`
+
+	// Templates are named sets of job fields that jobs and other
+	// templates can extend. Like Defaults they are applied to the jobs
+	// when the config is read.
+	Templates map[string]*commandConfig `json:"templates"`
 }
 
 type EnvConfig struct {
@@ -75,6 +211,13 @@ type EnvConfig struct {
 	// name of the field in the returned JSON or Username/Password (case
 	// sensitive) for db types.
 	//
+	// Alternatively values may have the URI form
+	// vault+engine://path?options#field where engine is one of kv, db, or
+	// aws-user. For kv secrets field may be a nested path into the JSON
+	// document such as foo.bar[0]. Options are default=value, which is
+	// used if the field is missing, and optional, which omits the variable
+	// if the field is missing.
+	//
 	// Secrets will only be fetched once and their fields re-used. So it
 	// is possible to get one db session and place its username in one
 	// variable and its password in another (for example, to use in a
@@ -91,29 +234,335 @@ type EnvConfig struct {
 	// A variable name being in this slice does not imply its membership in
 	// PassVariables.
 	VaultTemplateVariables []string `json:"vault-template"`
+
+	// TemplateEnvironment makes the variables that are passed through
+	// to the subprocess available to VaultTemplateVariables in addition
+	// to the VaultReplacements. Replacements take precedence over passed
+	// through variables of the same name.
+	TemplateEnvironment bool `json:"template-env"`
+
+	// SetVariables are static variables that will be set in the
+	// subprocess environment. Values may refer to variables in the
+	// supervisor environment as ${VAR} or ${VAR:-default}. These are set
+	// before Vault processing so they may also be listed in
+	// VaultReplacements or VaultTemplateVariables. These do not need to
+	// be listed in PassVariables.
+	SetVariables map[string]string `json:"set"`
+
+	// EnvFiles are dotenv formatted files that are loaded, in order,
+	// before SetVariables. They are otherwise treated the same as
+	// SetVariables. Relative paths are relative to the config file.
+	EnvFiles []string `json:"env-file"`
+
+	// UnsetVariables will never be passed through to the subprocess. This
+	// is most useful with PassAllVariables.
+	UnsetVariables []string `json:"unset"`
+}
+
+type VaultConfig struct {
+	// Address is the URL of the Vault server. If set this overrides
+	// VAULT_ADDR from the supervisor environment.
+	Address string `json:"address"`
+
+	// CACert is the path to a PEM encoded CA certificate used to verify
+	// the Vault server. If set this overrides VAULT_CACERT.
+	CACert string `json:"ca-cert"`
+
+	// AuthMethod is the method used to login to Vault. One of token
+	// (the default), approle, kubernetes, jwt, or cert. The token method
+	// uses VAULT_TOKEN or VAULT_ROLE_ID and VAULT_SECRET_ID from the
+	// supervisor environment. All other methods login using this config
+	// and supersede those variables.
+	AuthMethod string `json:"auth-method"`
+
+	// Mount is the path at which the auth method is mounted in Vault,
+	// without the auth/ prefix. Defaults to the name of the auth method.
+	Mount string `json:"mount"`
+
+	// Role is the Vault role to login as for the kubernetes and jwt auth
+	// methods, which require it, or the name of the certificate role for
+	// the cert auth method.
+	Role string `json:"role"`
+
+	// TokenFile is a file containing the JWT for the kubernetes and jwt
+	// auth methods. For kubernetes this defaults to the service account
+	// token mounted in the pod.
+	TokenFile string `json:"token-file"`
+
+	// RoleId is the AppRole role-id. If not set RoleIdFile and then
+	// VAULT_ROLE_ID will be used.
+	RoleId     string `json:"role-id"`
+	RoleIdFile string `json:"role-id-file"`
+
+	// SecretIdFile is a file containing the AppRole secret-id. If not set
+	// VAULT_SECRET_ID will be used. If SecretIdWrapped is set the
+	// secret-id is a response wrapping token that will be unwrapped
+	// before use.
+	SecretIdFile    string `json:"secret-id-file"`
+	SecretIdWrapped bool   `json:"secret-id-wrapped"`
+
+	// ClientCert and ClientKey are paths to the PEM encoded certificate
+	// and key used for the cert auth method.
+	ClientCert string `json:"client-cert"`
+	ClientKey  string `json:"client-key"`
+
+	// StartupRetry configures retries of Vault login, authentication,
+	// and secret fetches during startup. Errors that Vault returns for
+	// bad requests, such as permission denied, are not retried. If not
+	// set any Vault error during startup is fatal.
+	StartupRetry *RetryConfig `json:"startup-retry"`
+
+	// RenewalGrace is how long the supervisor will tolerate critical
+	// secret renewal failures before terminating all jobs. A successful
+	// renewal within the grace period resets it. If not set the first
+	// critical failure is fatal.
+	RenewalGrace Duration `json:"renewal-grace"`
+
+	// unwrappedSecretId is the secret-id from the first unwrap of a
+	// wrapped secret-id. Wrapping tokens can only be used once so a
+	// retried login must use it instead of unwrapping again.
+	unwrappedSecretId string
+}
+
+type RetryConfig struct {
+	// InitialBackoff is the time to wait after the first failure, this
+	// doubles after each subsequent failure. Defaults to 1s.
+	InitialBackoff Duration `json:"initial-backoff"`
+
+	// MaxBackoff is the maximum time to wait between attempts. Defaults
+	// to 30s.
+	MaxBackoff Duration `json:"max-backoff"`
+
+	// Deadline is the total time allowed for all startup Vault
+	// operations, after which the last error is fatal. Defaults to 2m.
+	// Secrets fetched after the deadline, such as those of jobs added
+	// when the config is reloaded, are not retried.
+	Deadline Duration `json:"deadline"`
+}
+
+// Duration is a time.Duration that is represented in config files as a
+// Go duration string, for example 1m30s
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("Duration.UnmarshalJSON: duration must be a string: %w", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("Duration.UnmarshalJSON: invalid duration %s", s)
+	}
+	*d = Duration(v)
+
+	return nil
+}
+
+// JobEnvConfig adjusts the environment of a single job. It is applied on
+// top of the environment prepared from the global EnvConfig.
+type JobEnvConfig struct {
+	// PassVariables restricts the variables from the global environment
+	// that are visible to this job. If not set all variables from the
+	// global environment are visible.
+	PassVariables []string `json:"pass"`
+
+	// SetVariables are static variables set in the job environment.
+	// These take precedence over all other variables.
+	SetVariables map[string]string `json:"set"`
+
+	// VaultReplacements are variables from the supervisor environment
+	// that will be resolved from Vault, in the same format as
+	// EnvConfig.VaultReplacements, and exported only to this job. Unlike
+	// the global config these do not need to be listed in PassVariables.
+	// Secrets shared with the global config or other jobs are only
+	// fetched once.
+	VaultReplacements []string `json:"vault-replace"`
+
+	// VaultTemplateVariables are templates, as in
+	// EnvConfig.VaultTemplateVariables, rendered with the global and job
+	// replacements and exported only to this job.
+	VaultTemplateVariables []string `json:"vault-template"`
 }
 
 type Command struct {
-	Name       string   `json:"name"`
-	Command    []string `json:"cmd"`
-	RunAsUser  string
-	RunAsGroup string
-	KillSignal syscall.Signal
+	// Name identifies the job in logs, signals, and config reloads. It
+	// must be unique and defaults to the base name of the command.
+	Name string `json:"name"`
+
+	// Command is the program and arguments of the job.
+	Command []string `json:"cmd"`
+
+	// Environment adjusts the environment of the job.
+	Environment *JobEnvConfig `json:"env"`
+
+	// Groups are the names of the supplementary groups for the job. If
+	// not set the groups that RunAsUser is a member of are used.
+	Groups []string `json:"groups"`
+
+	// WorkDir is the working directory of the job. A leading ~ refers to
+	// the home directory of RunAsUser. If not set the job inherits the
+	// working directory of the supervisor.
+	WorkDir string `json:"workdir"`
+
+	// Umask is the file mode creation mask of the job. If not set the job
+	// inherits the umask of the supervisor. In config files this is an
+	// octal string, for example "0027".
+	Umask *uint32 `json:"-"`
+
+	// Rlimits are resource limits set for the job before exec, keyed by
+	// name. Valid names are as, core, cpu, memlock, nofile, nproc, and
+	// stack. See Rlimit for the format of limits.
+	Rlimits map[string]Rlimit `json:"rlimits"`
+
+	// Cgroup places the job in its own cgroup v2 group with the
+	// configured limits. This requires the supervisor to run in a
+	// delegated cgroup v2 hierarchy.
+	Cgroup *CgroupConfig `json:"cgroup"`
+
+	// Capabilities adjusts the Linux capabilities of the job. If not set
+	// root jobs have all of the capabilities of the supervisor and other
+	// jobs have none.
+	Capabilities *CapabilityConfig `json:"capabilities"`
+
+	// SecureBits are the names of securebits flags set for the job, for
+	// example noroot. See capabilities(7).
+	SecureBits []string `json:"securebits"`
+
+	// NoNewPrivs prevents the job from gaining privileges through exec,
+	// for example by running setuid binaries.
+	NoNewPrivs bool `json:"no-new-privs"`
+
+	// Seccomp is the seccomp profile for the job, either default for the
+	// built-in profile or the path to a Docker/OCI JSON profile. Relative
+	// paths are relative to the config file. Setting a profile implies
+	// NoNewPrivs.
+	Seccomp string `json:"seccomp"`
+
+	// Namespaces are the names of the Linux namespaces the job is started
+	// in. Valid names are mount, pid, net, ipc, and uts. A new PID
+	// namespace implies a new mount namespace with its own /proc and a new
+	// network namespace has only a loopback interface.
+	Namespaces []string `json:"namespaces"`
+
+	// Hostname is the hostname of the job, it requires a uts namespace.
+	Hostname string `json:"hostname"`
+
+	FilesystemConfig
+
+	RunAsUser  string         `json:"-"`
+	RunAsGroup string         `json:"-"`
+	KillSignal syscall.Signal `json:"-"`
+
+	// SignalMap changes the signals forwarded to the job. Signals mapped
+	// to 0 are not forwarded. A signal that shuts down the supervisor or
+	// restarts the jobs is sent to the job as the signal it is mapped to
+	// instead of the kill signal. In config files this is a map of signal
+	// names to signal names or ignore, for example {"HUP": "USR2"}.
+	SignalMap map[syscall.Signal]syscall.Signal `json:"-"`
+
+	// SignalScope is which processes of the job receive forwarded signals
+	// and the kill signal. It is one of process, the default, group for
+	// the process group of the job, or session for every process in the
+	// session of the job, which includes background jobs of shells.
+	SignalScope string `json:"signal-scope"`
 }
 
-func (c *Command) UnmarshalJSON(d []byte) error {
-	type Alias Command
+// commandAlias has the fields of Command without its methods
+type commandAlias Command
+
+// commandConfig is the config file representation of a Command. Fields
+// that are parsed are replaced by their config file form.
+type commandConfig struct {
+	// KillSig is the name of the signal sent to stop the job, for
+	// example TERM. Defaults to KILL.
+	KillSig string `json:"kill-signal"`
+
+	// SignalMap changes the signals forwarded to the job, and the signal
+	// sent to stop it when a signal shuts down the supervisor or restarts
+	// the jobs. It maps signal names to signal names or ignore, for
+	// example {"HUP": "USR2"}.
+	SignalMap map[string]string `json:"signal-map"`
+
+	// RunAs is the user, or user:group, that the job runs as. Defaults to
+	// root.
+	RunAs string `json:"run-as"`
 
-	cfg := struct {
-		KillSig string `json:"kill-signal"`
-		RunAs   string `json:"run-as"`
-		*Alias
-	}{Alias: (*Alias)(c)}
+	// Umask is the file mode creation mask of the job as an octal string,
+	// for example "0027".
+	Umask string `json:"umask"`
+
+	// Disabled removes the job from the config, for example to disable a
+	// job of an included config by name
+	Disabled bool `json:"disabled"`
+
+	// Extends is the name of a template in JobsConfig.Templates. Fields
+	// of the template are used unless they are set on the job.
+	Extends string `json:"extends"`
+
+	*commandAlias
+}
+
+// UnmarshalJSON decodes a job and checks each of its fields. Checks that
+// depend on more than one field are in validate because included configs
+// and templates can each set some of the fields of a job.
+func (c *Command) UnmarshalJSON(d []byte) error {
+	cfg := commandConfig{commandAlias: (*commandAlias)(c)}
 
 	if err := json.Unmarshal(d, &cfg); err != nil {
 		return err
 	}
 
+	if err := validateRlimits(c.Rlimits); err != nil {
+		return fmt.Errorf("Command.UnmarshalJSON: %w", err)
+	}
+
+	if c.Cgroup != nil {
+		if _, err := c.Cgroup.files(); err != nil {
+			return fmt.Errorf("Command.UnmarshalJSON: %w", err)
+		}
+	}
+
+	if c.Capabilities != nil {
+		if err := c.Capabilities.validate(); err != nil {
+			return fmt.Errorf("Command.UnmarshalJSON: %w", err)
+		}
+	}
+
+	if _, err := parseSecureBits(c.SecureBits); err != nil {
+		return fmt.Errorf("Command.UnmarshalJSON: %w", err)
+	}
+
+	if _, err := parseNamespaces(c.Namespaces); err != nil {
+		return fmt.Errorf("Command.UnmarshalJSON: %w", err)
+	}
+
+	if err := c.FilesystemConfig.validate(); err != nil {
+		return fmt.Errorf("Command.UnmarshalJSON: %w", err)
+	}
+
+	if cfg.Umask != "" {
+		umask, err := strconv.ParseUint(cfg.Umask, 8, 32)
+		if err != nil || umask > 0777 {
+			return fmt.Errorf("Command.UnmarshalJSON: invalid umask %s", cfg.Umask)
+		}
+		c.Umask = new(uint32)
+		*c.Umask = uint32(umask)
+	}
+
+	var err error
+	if c.SignalMap, err = parseSignalMap(cfg.SignalMap); err != nil {
+		return fmt.Errorf("Command.UnmarshalJSON: signal-map: %w", err)
+	}
+
+	switch c.SignalScope {
+	case "":
+		c.SignalScope = SignalScopeProcess
+	case SignalScopeProcess, SignalScopeGroup, SignalScopeSession:
+	default:
+		return fmt.Errorf("Command.UnmarshalJSON: invalid signal-scope %s", c.SignalScope)
+	}
+
 	var ok bool
 	if cfg.KillSig != "" {
 		if c.KillSignal, ok = signalMap[cfg.KillSig]; !ok {
@@ -2,8 +2,11 @@ package supervise
 
 import (
 	"encoding/json"
+	"os"
+	"path/filepath"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -92,3 +95,76 @@ func TestUnmarshalCommandName(t *testing.T) {
 	assert.NoError(t, json.Unmarshal(cfg, &cmd))
 	assert.Equal(t, "", cmd.Name)
 }
+
+func TestDurationUnmarshal(t *testing.T) {
+	c := &RetryConfig{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"deadline": "1m30s"}`), c))
+	assert.Equal(t, Duration(90*time.Second), c.Deadline)
+
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"deadline": "soon"}`), c), "invalid duration soon")
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"deadline": 10}`), c), "duration must be a string")
+}
+
+func TestReadAppConfigEnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "simplevisor.json")
+	assert.NoError(t, os.WriteFile(cfgPath, []byte(`{"env": {"env-file": ["app.env", "/etc/abs.env"]}, "jobs": {}}`), 0600))
+
+	cfg, err := ReadAppConfig(cfgPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "app.env"), "/etc/abs.env"}, cfg.Environment.EnvFiles)
+}
+
+func TestUnmarshalCommandUmask(t *testing.T) {
+	cmd := &Command{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"cmd": ["test"]}`), &cmd))
+	assert.Nil(t, cmd.Umask)
+
+	cmd = &Command{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"cmd": ["test"], "umask": "0027", "workdir": "~/app"}`), &cmd))
+	assert.Equal(t, uint32(0027), *cmd.Umask)
+	assert.Equal(t, "~/app", cmd.WorkDir)
+
+	for _, v := range []string{"0999", "01777", "foo"} {
+		cmd = &Command{}
+		assert.ErrorContains(t, json.Unmarshal([]byte(`{"cmd": ["test"], "umask": "`+v+`"}`), &cmd), "invalid umask "+v)
+	}
+}
+
+func TestUnmarshalCommandSignalMap(t *testing.T) {
+	cmd := &Command{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"signal-map": {"TERM": "QUIT", "HUP": "USR2", "WINCH": "ignore"}}`), cmd))
+	assert.Equal(t, map[syscall.Signal]syscall.Signal{
+		syscall.SIGTERM:  syscall.SIGQUIT,
+		syscall.SIGHUP:   syscall.SIGUSR2,
+		syscall.SIGWINCH: 0,
+	}, cmd.SignalMap)
+
+	cmd = &Command{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"cmd": ["test"]}`), cmd))
+	assert.Nil(t, cmd.SignalMap)
+
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"signal-map": {"FOO": "QUIT"}}`), &Command{}), "signal-map: invalid signal FOO")
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"signal-map": {"TERM": "SIGQUIT"}}`), &Command{}), "signal-map: invalid signal SIGQUIT")
+}
+
+func TestUnmarshalCommandSignalScope(t *testing.T) {
+	cmd := &Command{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"cmd": ["test"]}`), cmd))
+	assert.Equal(t, SignalScopeProcess, cmd.SignalScope)
+
+	cmd = &Command{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"signal-scope": "session"}`), cmd))
+	assert.Equal(t, SignalScopeSession, cmd.SignalScope)
+
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"signal-scope": "tree"}`), &Command{}), "invalid signal-scope tree")
+}
+
+func TestReadAppConfigSignalJobs(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "simplevisor.json")
+	assert.NoError(t, os.WriteFile(cfgPath, []byte(`{"jobs": {"main": [{"name": "web", "cmd": ["web"]}]}, "signals": {"USR1": {"action": "forward", "jobs": ["web", "worker"]}}}`), 0600))
+
+	_, err := ReadAppConfig(cfgPath)
+	assert.ErrorContains(t, err, "unknown main job worker")
+}
@@ -0,0 +1,96 @@
+package supervise
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/sys/unix"
+)
+
+var namespaceFlags = map[string]uintptr{
+	"mount": unix.CLONE_NEWNS,
+	"pid":   unix.CLONE_NEWPID,
+	"net":   unix.CLONE_NEWNET,
+	"ipc":   unix.CLONE_NEWIPC,
+	"uts":   unix.CLONE_NEWUTS,
+}
+
+// parseNamespaces returns the clone flags for the named namespaces. A PID
+// namespace implies a mount namespace so that /proc can be mounted for
+// the new namespace without affecting the supervisor.
+func parseNamespaces(names []string) (uintptr, error) {
+	var flags uintptr
+	for _, name := range names {
+		f, ok := namespaceFlags[name]
+		if !ok {
+			valid := []string{}
+			for n := range namespaceFlags {
+				valid = append(valid, n)
+			}
+			sort.Strings(valid)
+			return 0, fmt.Errorf("unknown namespace %s, must be one of %v", name, valid)
+		}
+		flags |= f
+	}
+
+	if flags&unix.CLONE_NEWPID != 0 {
+		flags |= unix.CLONE_NEWNS
+	}
+
+	return flags, nil
+}
+
+// setupNamespaces prepares the namespaces that the child was started in.
+// Mounts are made private so that changes do not propagate back to the
+// supervisor, /proc is remounted for a new PID namespace, the loopback
+// interface is brought up for a new network namespace, and the hostname
+// is set for a new UTS namespace. root is the directory the job will be
+// chrooted to, if any. This must be called before dropping privileges.
+func setupNamespaces(flags uintptr, hostname, root string) (string, error) {
+	if flags&unix.CLONE_NEWNS != 0 {
+		if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+			return "mount /", err
+		}
+	}
+
+	if flags&unix.CLONE_NEWPID != 0 {
+		proc := filepath.Join(root, "/proc")
+		if err := unix.Mount("proc", proc, "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+			return "mount " + proc, err
+		}
+	}
+
+	if flags&unix.CLONE_NEWNET != 0 {
+		if err := setLinkUp("lo"); err != nil {
+			return "link lo", err
+		}
+	}
+
+	if flags&unix.CLONE_NEWUTS != 0 && hostname != "" {
+		if err := unix.Sethostname([]byte(hostname)); err != nil {
+			return "hostname " + hostname, err
+		}
+	}
+
+	return "", nil
+}
+
+func setLinkUp(name string) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return err
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+}
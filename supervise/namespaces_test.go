@@ -0,0 +1,39 @@
+package supervise
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseNamespaces(t *testing.T) {
+	flags, err := parseNamespaces([]string{"net", "uts"})
+	assert.NoError(t, err)
+	assert.Equal(t, uintptr(unix.CLONE_NEWNET|unix.CLONE_NEWUTS), flags)
+
+	flags, err = parseNamespaces([]string{"pid"})
+	assert.NoError(t, err)
+	assert.Equal(t, uintptr(unix.CLONE_NEWPID|unix.CLONE_NEWNS), flags)
+
+	flags, err = parseNamespaces(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uintptr(0), flags)
+
+	_, err = parseNamespaces([]string{"user"})
+	assert.ErrorContains(t, err, "unknown namespace user, must be one of [ipc mount net pid uts]")
+}
+
+func TestCommandUnmarshalNamespaces(t *testing.T) {
+	c := &Command{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"namespaces": ["uts", "net"], "hostname": "helper"}`), c))
+	assert.Equal(t, []string{"uts", "net"}, c.Namespaces)
+	assert.Equal(t, "helper", c.Hostname)
+
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"namespaces": ["time"]}`), &Command{}), "unknown namespace time")
+
+	c = &Command{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"hostname": "helper"}`), c))
+	assert.EqualError(t, c.validate(), "hostname requires a uts namespace")
+}
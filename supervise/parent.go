@@ -2,9 +2,12 @@ package supervise
 
 import (
 	"context"
+	"fmt"
+	"maps"
 	"os"
+	"reflect"
+	"slices"
 	"sync"
-	"syscall"
 	"time"
 
 	"code.crute.us/mcrute/golib/secrets"
@@ -13,11 +16,38 @@ import (
 	"golang.org/x/sys/unix"
 )
 
+const (
+	// How long jobs have to exit after being sent their kill signal
+	jobStopTimeout = 10 * time.Second
+
+	// How long the config file must be unchanged before it is reloaded
+	configReloadDelay = time.Second
+)
+
 type SupervisorParent struct {
+	// ControlSocket is the path of a unix socket that accepts commands,
+	// such as reload. If empty there is no control socket.
+	ControlSocket string
+
+	// WatchConfig reloads the config when the config file changes
+	WatchConfig bool
+
 	handles []*CommandHandle
 	cancel  func()
 	wg      *sync.WaitGroup
 	log     *logging.InternalLogger
+
+	// chld is notified when there may be children to reap
+	chld chan os.Signal
+
+	cfgLoc string
+	cfg    *AppConfig
+	env    *JobEnvironment
+	runner *CommandRunner
+
+	// watch is the watch of the config files, nil unless WatchConfig is
+	// set
+	watch *configWatch
 }
 
 func (p *SupervisorParent) Main(cfgLoc string, disableVault bool, discoverVault bool) {
@@ -25,10 +55,12 @@ func (p *SupervisorParent) Main(cfgLoc string, disableVault bool, discoverVault
 	defer cancel()
 
 	p.cancel = cancel
+	p.cfgLoc = cfgLoc
 	p.handles = []*CommandHandle{}
 	p.wg = &sync.WaitGroup{}
 
-	sigs := SetupSignals()
+	sigs, chld := SetupSignals()
+	p.chld = chld
 	secretFailures := make(chan error)
 
 	p.log = &logging.InternalLogger{
@@ -44,14 +76,29 @@ func (p *SupervisorParent) Main(cfgLoc string, disableVault bool, discoverVault
 		p.fatal("parentMain: error loading config: %s", err)
 		return
 	}
+	p.cfg = cfg
+
+	vaultCfg := cfg.Vault
+	if vaultCfg == nil {
+		vaultCfg = &VaultConfig{}
+	}
+	retrier := newStartupRetrier(vaultCfg.StartupRetry, p.log.Logf)
 
 	var vc secrets.ClientManager
 	if !disableVault {
-		if discoverVault {
-			vc, err = secrets.NewAutodiscoverVaultClient(ctx)
-		} else {
-			vc, err = secrets.NewVaultClient(&secrets.VaultClientConfig{})
+		if err := retrier.Do(ctx, "vault login", func() error { return vaultCfg.Login(ctx) }); err != nil {
+			p.fatal("parentMain: unable to login to vault: %s", err)
+			return
 		}
+
+		err = retrier.Do(ctx, "vault setup", func() (err error) {
+			if discoverVault {
+				vc, err = secrets.NewAutodiscoverVaultClient(ctx)
+			} else {
+				vc, err = secrets.NewVaultClient(&secrets.VaultClientConfig{})
+			}
+			return err
+		})
 		if err != nil {
 			p.fatal("parentMain: unable to setup vault: %s", err)
 			return
@@ -60,87 +107,107 @@ func (p *SupervisorParent) Main(cfgLoc string, disableVault bool, discoverVault
 		vc, _ = secrets.NewNoopClient()
 	}
 
-	if err := vc.Authenticate(ctx); err != nil {
+	if err := retrier.Do(ctx, "vault authentication", func() error { return vc.Authenticate(ctx) }); err != nil {
 		p.fatal("parentMain: unable to auth vault: %s", err)
 		return
 	}
 
+	vaultCfg.ClearToken()
+
 	// TODO: Support VAULT_TOKEN
-	env, err := PrepareEnvironment(ctx, cfg.Environment, vc, "")
+	env, err := NewJobEnvironment(ctx, cfg.Environment, &retryingClient{vc, retrier}, "")
 	if err != nil {
 		p.fatal("parentMain: unable to prepare environment: %s", err)
 		return
 	}
 
+	p.env = env
+
+	jobEnvs, err := p.jobEnvironments(ctx, slices.Concat(cfg.Jobs.Init, cfg.Jobs.Main))
+	if err != nil {
+		p.fatal("parentMain: %s", err)
+		return
+	}
+
 	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, uintptr(1), 0, 0, 0); err != nil {
 		p.fatal("parentMain: unable to become subreaper: %s", err)
 		return
 	}
 
-	go jobs.SecretsLogger(ctx, p.wg, vc, p.log, secretFailures)
+	go jobs.SecretsLogger(ctx, p.wg, vc, p.log, secretFailures, time.Duration(vaultCfg.RenewalGrace))
 	go vc.Run(ctx, p.wg)
 
-	runner := &CommandRunner{
-		Logger:      p.log,
-		BaseContext: ctx,
-		WaitGroup:   p.wg,
-		Environment: env,
+	p.runner = &CommandRunner{
+		Logger:          p.log,
+		BaseContext:     ctx,
+		WaitGroup:       p.wg,
+		Environment:     env.Global,
+		JobEnvironments: jobEnvs,
 	}
 
-	if cfg.Jobs.Init != nil {
-		for _, js := range cfg.Jobs.Init {
-			p.log.Logf("parentMain: attempting to start job %s", js.Name)
+	if err := p.enableCgroups(slices.Concat(cfg.Jobs.Init, cfg.Jobs.Main)); err != nil {
+		p.fatal("parentMain: %s", err)
+		return
+	}
 
-			hnd, err := runner.Run(js)
-			if err != nil {
-				p.fatal("parentMain: error starting init job %s: %s", js.Name, err)
-				return
-			}
-			if err := hnd.Wait(); err != nil {
-				p.fatal("parentMain: error running init job %s: %s", js.Name, err)
-				return
-			}
-			if exit := hnd.ExitCode(); exit != 0 {
-				p.fatal("parentMain: error init job %s exited non-zero: %d", js.Name, exit)
-				return
-			}
-			hnd.Cleanup()
+	for _, js := range cfg.Jobs.Init {
+		if err := p.runInitJob(js); err != nil {
+			p.fatal("parentMain: %s", err)
+			return
 		}
 	}
 
 	// TODO: Restart if crashed
-	for _, js := range cfg.Jobs.Main {
-		hnd, err := runner.Run(js)
+	if err := p.startJobs(cfg.Jobs.Main); err != nil {
+		p.fatal("parentMain: %s", err)
+		return
+	}
+
+	var controls chan *controlRequest
+	if p.ControlSocket != "" {
+		l, err := listenControl(p.ControlSocket)
 		if err != nil {
-			p.fatal("parentMain: error starting main job %s: %s", js.Name, err)
+			p.fatal("parentMain: unable to create control socket: %s", err)
 			return
 		}
-		p.handles = append(p.handles, hnd)
+		controls = make(chan *controlRequest)
+		go ControlServer(ctx, p.wg, l, p.log, controls)
 	}
 
-	// TODO: Clean this up
-	// Reap children and propogate signals until the end
-	for {
-		exits, err := ReapChildren()
+	var configChanges chan struct{}
+	if p.WatchConfig {
+		w, err := watchConfig(cfgLoc)
 		if err != nil {
-			p.log.Logf("Error reaping children: %s", err)
-		} else {
-			for _, e := range exits {
-				p.log.Logf("Reaped child %d with exit %d", e.Pid, e.Status)
-			}
+			p.fatal("parentMain: unable to watch config: %s", err)
+			return
+		}
+		if err := w.watchIncludes(cfgLoc, p.cfg.Include); err != nil {
+			p.fatal("parentMain: unable to watch config: %s", err)
+			return
 		}
+		p.watch = w
+		configChanges = make(chan struct{})
+		go ConfigWatcher(ctx, p.wg, w, cfgLoc, p.log, configChanges)
+	}
 
+	// Every event is handled as soon as it arrives, jobs are reaped when
+	// the supervisor is notified that a child has exited. Config changes
+	// are only reloaded once the config has stopped changing.
+	var reloadAfter <-chan time.Time
+	for {
 		select {
+		case s := <-p.chld:
+			p.reap()
+			p.handleSignal(s)
 		case s := <-sigs:
-			switch s {
-			case syscall.SIGTERM, syscall.SIGINT:
-				p.Terminate(true)
-				return
-			}
-
-			for _, h := range p.handles {
-				h.Signal(s)
-			}
+			p.handleSignal(s)
+		case req := <-controls:
+			req.result <- p.reload()
+		case <-configChanges:
+			reloadAfter = time.After(configReloadDelay)
+		case <-reloadAfter:
+			reloadAfter = nil
+			p.reload()
 		case f := <-secretFailures:
 			p.log.Logf("%s", f)
 			p.Terminate(false)
@@ -148,7 +215,312 @@ func (p *SupervisorParent) Main(cfgLoc string, disableVault bool, discoverVault
 		case <-ctx.Done():
 			p.Terminate(true)
 			return
-		case <-time.After(time.Second):
+		}
+	}
+}
+
+// handleSignal takes the configured action for a signal received by the
+// supervisor
+func (p *SupervisorParent) handleSignal(s os.Signal) {
+	a := p.cfg.Signals.Action(s)
+	switch a.Action {
+	case SignalActionShutdown:
+		p.log.Logf("Received %s, shutting down", s)
+		p.terminate(s, true)
+	case SignalActionReload:
+		p.log.Logf("Received %s, reloading config", s)
+		p.reload()
+	case SignalActionRestart:
+		p.log.Logf("Received %s, restarting jobs", s)
+		p.stopJobs(p.handles, s)
+		if err := p.startJobs(p.cfg.Jobs.Main); err != nil {
+			p.fatal("parentMain: %s", err)
+		}
+	case SignalActionForward:
+		for _, h := range p.handles {
+			if len(a.Jobs) == 0 || slices.Contains(a.Jobs, h.name) {
+				h.Signal(s)
+			}
+		}
+	}
+}
+
+// reload reads the config again and applies the changes to the main
+// jobs, which are matched by name. Added jobs are started, removed jobs
+// are stopped, and changed jobs are restarted. Unchanged jobs are left
+// running. The environment, Vault config, and init jobs are not changed
+// by a reload. If the new config is invalid, or its jobs can not be
+// started, the current config is kept and its stopped jobs are restarted.
+func (p *SupervisorParent) reload() error {
+	err := p.applyConfig()
+	if err != nil {
+		p.log.Logf("Error reloading config, keeping current config: %s", err)
+	}
+
+	// The includes may have changed
+	if p.watch != nil {
+		if werr := p.watch.watchIncludes(p.cfgLoc, p.cfg.Include); werr != nil {
+			p.log.Logf("Error watching config includes: %s", werr)
+		}
+	}
+
+	return err
+}
+
+func (p *SupervisorParent) applyConfig() error {
+	cfg, err := ReadAppConfig(p.cfgLoc)
+	if err != nil {
+		return err
+	}
+
+	current := jobsByName(p.cfg.Jobs.Main)
+
+	var start []*Command
+	jobEnvs := map[*Command][]string{}
+	for _, js := range cfg.Jobs.Main {
+		old, ok := current[js.Name]
+		if ok && reflect.DeepEqual(old, js) {
+			// Unchanged jobs keep their environment for later restarts
+			if env, ok := p.runner.JobEnvironments[old]; ok {
+				jobEnvs[js] = env
+			}
+			delete(current, js.Name)
+			continue
+		}
+		start = append(start, js)
+	}
+
+	startEnvs, err := p.jobEnvironments(p.runner.BaseContext, start)
+	if err != nil {
+		return err
+	}
+	maps.Copy(jobEnvs, startEnvs)
+
+	if err := p.enableCgroups(start); err != nil {
+		return err
+	}
+
+	// Jobs remaining in current were removed or changed
+	var stop []*CommandHandle
+	for _, h := range p.handles {
+		if _, ok := current[h.name]; ok {
+			stop = append(stop, h)
+		}
+	}
+
+	// Jobs that were running are restarted if the new jobs can't start
+	var restore []*Command
+	for _, js := range p.cfg.Jobs.Main {
+		if slices.ContainsFunc(stop, func(h *CommandHandle) bool { return h.name == js.Name && !h.exited }) {
+			restore = append(restore, js)
+		}
+	}
+
+	if len(stop) == 0 && len(start) == 0 {
+		p.log.Logf("Reloading config, no jobs have changed")
+	} else {
+		p.log.Logf("Reloading config, stopping %d jobs and starting %d jobs", len(stop), len(start))
+	}
+	p.stopJobs(stop, nil)
+
+	oldMain, oldSignals, oldEnvs := p.cfg.Jobs.Main, p.cfg.Signals, p.runner.JobEnvironments
+	p.cfg.Jobs.Main = cfg.Jobs.Main
+	p.cfg.Signals = cfg.Signals
+	p.runner.JobEnvironments = jobEnvs
+
+	started := len(p.handles)
+	if err := p.startJobs(start); err != nil {
+		p.log.Logf("Error starting jobs, restarting the jobs of the current config: %s", err)
+		p.stopJobs(p.handles[started:], nil)
+
+		p.cfg.Jobs.Main = oldMain
+		p.cfg.Signals = oldSignals
+		p.runner.JobEnvironments = oldEnvs
+
+		if rerr := p.startJobs(restore); rerr != nil {
+			return fmt.Errorf("%w, unable to restart current jobs: %w", err, rerr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// jobsByName indexes jobs by name, which is unique in a valid config
+func jobsByName(jobs []*Command) map[string]*Command {
+	out := make(map[string]*Command, len(jobs))
+	for _, js := range jobs {
+		out[js.Name] = js
+	}
+	return out
+}
+
+// jobEnvironments prepares the environment of each job that has its own
+// environment config
+func (p *SupervisorParent) jobEnvironments(ctx context.Context, jobs []*Command) (map[*Command][]string, error) {
+	jobEnvs := map[*Command][]string{}
+	for _, js := range jobs {
+		if js.Environment == nil {
+			continue
+		}
+		env, err := p.env.For(ctx, js.Environment)
+		if err != nil {
+			return nil, fmt.Errorf("unable to prepare environment for job %s: %w", js.Name, err)
+		}
+		jobEnvs[js] = env
+	}
+	return jobEnvs, nil
+}
+
+// enableCgroups sets up cgroups for the runner if any of the jobs have a
+// cgroup config and they are not already set up
+func (p *SupervisorParent) enableCgroups(jobs []*Command) error {
+	if p.runner.Cgroups != nil || !slices.ContainsFunc(jobs, func(c *Command) bool { return c.Cgroup != nil }) {
+		return nil
+	}
+
+	cgroups, err := NewCgroupManager()
+	if err != nil {
+		return fmt.Errorf("unable to setup cgroups: %w", err)
+	}
+	p.runner.Cgroups = cgroups
+
+	return nil
+}
+
+// startJobs starts jobs and adds them to the running jobs
+func (p *SupervisorParent) startJobs(jobs []*Command) error {
+	for _, js := range jobs {
+		hnd, err := p.runner.Run(js)
+		if err != nil {
+			return fmt.Errorf("error starting main job %s: %w", js.Name, err)
+		}
+		p.handles = append(p.handles, hnd)
+	}
+	return nil
+}
+
+// runInitJob runs an init job to completion. Like main jobs that exit,
+// any processes left behind by the job are killed and its cgroup is
+// removed, whether or not the job succeeded.
+func (p *SupervisorParent) runInitJob(js *Command) error {
+	p.log.Logf("parentMain: attempting to start job %s", js.Name)
+
+	hnd, err := p.runner.Run(js)
+	if err != nil {
+		return fmt.Errorf("error starting init job %s: %w", js.Name, err)
+	}
+	defer hnd.Cleanup()
+
+	err = hnd.Wait()
+	hnd.KillStragglers()
+	if n := hnd.OOMKills(); n > 0 {
+		p.log.Logf("parentMain: init job %s had %d processes killed by the OOM killer", js.Name, n)
+	}
+	if rerr := hnd.RemoveCgroup(); rerr != nil {
+		p.log.Logf("parentMain: %s", rerr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error running init job %s: %w", js.Name, err)
+	}
+	if exit := hnd.ExitCode(); exit != 0 {
+		return fmt.Errorf("error init job %s exited non-zero: %d", js.Name, exit)
+	}
+	return nil
+}
+
+// stopJobs stops jobs, waiting for them to exit before killing any
+// remaining processes, and removes them from the running jobs. sig is the
+// signal that caused the jobs to be stopped, if any, see
+// CommandHandle.Stop.
+func (p *SupervisorParent) stopJobs(handles []*CommandHandle, sig os.Signal) {
+	// Jobs that have exited already had their remaining processes killed
+	// when they were reaped. Their process group ids may since have been
+	// reused so they must not be signalled again.
+	running := slices.DeleteFunc(slices.Clone(handles), func(h *CommandHandle) bool { return h.exited })
+
+	for _, h := range running {
+		h.Stop(sig)
+	}
+
+	p.waitForJobs(running, jobStopTimeout)
+
+	for _, h := range running {
+		if !h.exited {
+			h.KillStragglers()
+		}
+	}
+
+	// The jobs were killed so this only waits for them to be reaped
+	p.waitForJobs(running, jobStopTimeout)
+
+	for _, h := range handles {
+		h.Cleanup()
+		h.RemoveCgroup()
+	}
+
+	remaining := []*CommandHandle{}
+	for _, h := range p.handles {
+		if !slices.Contains(handles, h) {
+			remaining = append(remaining, h)
+		}
+	}
+	p.handles = remaining
+}
+
+// jobExited handles the exit of the job with pid, if there is one. Any
+// processes left behind by the job are killed, OOM kills are reported,
+// and the cgroup of the job is removed.
+func (p *SupervisorParent) jobExited(pid int) {
+	for _, h := range p.handles {
+		if h.Pid() != pid || h.exited {
+			continue
+		}
+		h.exited = true
+		h.KillStragglers()
+		if n := h.OOMKills(); n > 0 {
+			p.log.Logf("Job with pid %d had %d processes killed by the OOM killer", pid, n)
+		}
+		if err := h.RemoveCgroup(); err != nil {
+			p.log.Logf("Error removing cgroup: %s", err)
+		}
+	}
+}
+
+// reap reaps all exited children and handles the exit of any jobs
+func (p *SupervisorParent) reap() {
+	exits, err := ReapChildren()
+	if err != nil {
+		p.log.Logf("Error reaping children: %s", err)
+	}
+
+	for _, e := range exits {
+		p.log.Logf("Reaped child %d with exit %d", e.Pid, e.Status)
+		p.jobExited(e.Pid)
+	}
+}
+
+// waitForJobs reaps children as they exit until every one of handles has
+// exited or the timeout expires
+func (p *SupervisorParent) waitForJobs(handles []*CommandHandle, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		// A child that exits after reaping notifies chld so it is not
+		// missed by the select
+		p.reap()
+
+		if !slices.ContainsFunc(handles, func(h *CommandHandle) bool { return !h.exited }) {
+			return
+		}
+
+		select {
+		case <-p.chld:
+		case <-timer.C:
+			p.log.Logf("Timed out waiting for jobs to exit, killing remaining processes")
+			return
 		}
 	}
 }
@@ -158,14 +530,24 @@ func (p *SupervisorParent) fatal(msg string, args ...any) {
 	p.Terminate(false)
 }
 
+// Terminate stops all jobs, waiting for them to exit before killing any
+// remaining processes, and then exits the supervisor
 func (p *SupervisorParent) Terminate(success bool) {
-	for _, h := range p.handles {
-		h.Terminate()
-	}
+	p.terminate(nil, success)
+}
+
+// terminate is Terminate for a shutdown caused by the supervisor
+// receiving sig, which is nil for other shutdowns
+func (p *SupervisorParent) terminate(sig os.Signal, success bool) {
+	p.stopJobs(p.handles, sig)
 
 	p.cancel()
 	p.wg.Wait()
 
+	// The log writer may have stopped, or not yet started, before writing
+	// the reason for terminating
+	logging.FlushLogs(os.Stdout, p.log)
+
 	ReapChildren()
 
 	if success {
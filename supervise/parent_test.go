@@ -0,0 +1,172 @@
+package supervise
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"code.crute.us/mcrute/simplevisor/supervise/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMain runs the child side of CommandRunner.Run when the test binary
+// is started as a job, like main
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == "--mode=child" {
+		ChildMain()
+	}
+	os.Exit(m.Run())
+}
+
+// testParent returns a supervisor that runs the jobs in the config at
+// cfgLoc
+func testParent(t *testing.T, cfgLoc string) *SupervisorParent {
+	cfg, err := ReadAppConfig(cfgLoc)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	log := &logging.InternalLogger{Logs: make(chan *logging.LogRecord, 100), Pool: logging.NewBufferPool()}
+	p := &SupervisorParent{
+		chld:   notifyChild(t),
+		log:    log,
+		cfgLoc: cfgLoc,
+		cfg:    cfg,
+		runner: &CommandRunner{
+			Logger:          log,
+			BaseContext:     ctx,
+			WaitGroup:       &sync.WaitGroup{},
+			Environment:     []string{"PATH=/usr/bin:/bin"},
+			JobEnvironments: map[*Command][]string{},
+		},
+	}
+	t.Cleanup(func() { p.stopJobs(p.handles, nil) })
+
+	return p
+}
+
+func TestWaitForJobsReapsOnExit(t *testing.T) {
+	p := &SupervisorParent{
+		chld: notifyChild(t),
+		log:  &logging.InternalLogger{Logs: make(chan *logging.LogRecord, 100), Pool: logging.NewBufferPool()},
+	}
+
+	cmd := exec.Command("sleep", "0.1")
+	assert.NoError(t, cmd.Start())
+	p.handles = []*CommandHandle{{cmd: cmd}}
+
+	start := time.Now()
+	p.waitForJobs(p.handles, 5*time.Second)
+
+	assert.True(t, p.handles[0].exited)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestWaitForJobsTimeout(t *testing.T) {
+	p := &SupervisorParent{
+		chld: notifyChild(t),
+		log:  &logging.InternalLogger{Logs: make(chan *logging.LogRecord, 100), Pool: logging.NewBufferPool()},
+	}
+
+	cmd := exec.Command("sleep", "10")
+	assert.NoError(t, cmd.Start())
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	p.handles = []*CommandHandle{{cmd: cmd}}
+
+	p.waitForJobs(p.handles, 200*time.Millisecond)
+
+	assert.False(t, p.handles[0].exited)
+}
+
+func notifyChild(t *testing.T) chan os.Signal {
+	chld := make(chan os.Signal, 1)
+	signal.Notify(chld, syscall.SIGCHLD)
+	t.Cleanup(func() { signal.Stop(chld) })
+	return chld
+}
+
+func TestJobsByName(t *testing.T) {
+	a, b := &Command{Name: "a"}, &Command{Name: "b"}
+	assert.Equal(t, map[string]*Command{"a": a, "b": b}, jobsByName([]*Command{a, b}))
+}
+
+func TestReloadKeepsJobsWhenStartFails(t *testing.T) {
+	cfgLoc := writeTestConfig(t, `{"jobs": {"main": [{"name": "web", "cmd": ["sleep", "30"]}]}}`)
+	p := testParent(t, cfgLoc)
+	assert.NoError(t, p.startJobs(p.cfg.Jobs.Main))
+	old := p.handles[0]
+
+	// The new worker job starts before the changed web job fails
+	assert.NoError(t, os.WriteFile(cfgLoc, []byte(`{
+		"jobs": {"main": [
+			{"name": "worker", "cmd": ["sleep", "30"]},
+			{"name": "web", "cmd": ["/nonexistent/web"]}
+		]},
+		"signals": {"USR1": "ignore"}
+	}`), 0600))
+
+	assert.ErrorContains(t, p.reload(), "error starting main job web")
+
+	assert.Len(t, p.handles, 1)
+	h := p.handles[0]
+	assert.Equal(t, "web", h.name)
+	assert.False(t, h.exited)
+	assert.NotEqual(t, old.Pid(), h.Pid())
+	assert.NoError(t, syscall.Kill(h.Pid(), 0))
+
+	assert.Len(t, p.cfg.Jobs.Main, 1)
+	assert.Equal(t, []string{"sleep", "30"}, p.cfg.Jobs.Main[0].Command)
+	assert.Nil(t, p.cfg.Signals)
+}
+
+func TestStopJobsSkipsExitedJobs(t *testing.T) {
+	p := testParent(t, writeTestConfig(t, `{"jobs": {"main": [{"name": "web", "cmd": ["sleep", "30"]}]}}`))
+	assert.NoError(t, p.startJobs(p.cfg.Jobs.Main))
+	pid := p.handles[0].Pid()
+	t.Cleanup(func() {
+		syscall.Kill(pid, syscall.SIGKILL)
+		syscall.Wait4(pid, nil, 0, nil)
+	})
+
+	// Stands in for a job that was reaped and whose process group id has
+	// been reused by another process
+	p.handles[0].exited = true
+	p.stopJobs(p.handles, nil)
+	assert.Empty(t, p.handles)
+
+	time.Sleep(50 * time.Millisecond)
+	wpid, err := syscall.Wait4(pid, nil, syscall.WNOHANG, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, wpid, "process group of an exited job was killed")
+}
+
+func TestRunInitJobKillsStragglers(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	p := testParent(t, writeTestConfig(t, `{"jobs": {"init": [
+		{"name": "daemon", "cmd": ["sh", "-c", "sleep 30 & echo $! > `+pidFile+`"]}
+	]}}`))
+	assert.NoError(t, p.runInitJob(p.cfg.Jobs.Init[0]))
+
+	b, err := os.ReadFile(pidFile)
+	assert.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	assert.NoError(t, err)
+
+	// The background process was reparented so it may be left as a
+	// zombie once it has been killed
+	assert.Eventually(t, func() bool {
+		stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		return err != nil || strings.Contains(string(stat), ") Z ")
+	}, time.Second, 10*time.Millisecond)
+}
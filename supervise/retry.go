@@ -0,0 +1,119 @@
+package supervise
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"code.crute.us/mcrute/golib/secrets"
+)
+
+const (
+	defaultRetryInitialBackoff = time.Second
+	defaultRetryMaxBackoff     = 30 * time.Second
+	defaultRetryDeadline       = 2 * time.Minute
+)
+
+// startupRetrier retries operations with exponential backoff until a
+// deadline, shared by all operations, has passed.
+type startupRetrier struct {
+	initial  time.Duration
+	max      time.Duration
+	deadline time.Time
+	logf     func(string, ...any)
+}
+
+// newStartupRetrier creates a retrier from the config. The deadline
+// starts when the retrier is created. A nil config creates a retrier
+// that never retries.
+func newStartupRetrier(c *RetryConfig, logf func(string, ...any)) *startupRetrier {
+	if c == nil {
+		return &startupRetrier{deadline: time.Now(), logf: logf}
+	}
+
+	r := &startupRetrier{
+		initial:  time.Duration(c.InitialBackoff),
+		max:      time.Duration(c.MaxBackoff),
+		deadline: time.Now().Add(defaultRetryDeadline),
+		logf:     logf,
+	}
+	if r.initial <= 0 {
+		r.initial = defaultRetryInitialBackoff
+	}
+	if r.max <= 0 {
+		r.max = defaultRetryMaxBackoff
+	}
+	if c.Deadline > 0 {
+		r.deadline = time.Now().Add(time.Duration(c.Deadline))
+	}
+
+	return r
+}
+
+// Do calls fn until it succeeds, fails permanently, the context is
+// cancelled, or the next attempt would happen after the deadline. The
+// last error is returned.
+func (r *startupRetrier) Do(ctx context.Context, what string, fn func() error) error {
+	wait := r.initial
+	for {
+		err := fn()
+		if err == nil || isPermanentVaultError(err) || time.Now().Add(wait).After(r.deadline) {
+			return err
+		}
+
+		r.logf("%s failed, retrying in %s: %s", what, wait, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		if wait *= 2; wait > r.max {
+			wait = r.max
+		}
+	}
+}
+
+// isPermanentVaultError returns true if err is from a request that Vault
+// rejected in a way that retrying will not fix, such as permission denied
+// or an unknown role or secret. These are 4xx errors other than 429 Too
+// Many Requests.
+func isPermanentVaultError(err error) bool {
+	code, ok := vaultStatusCode(err)
+	return ok && code >= 400 && code < 500 && code != http.StatusTooManyRequests
+}
+
+// retryingClient retries the secret fetches used when preparing the
+// environment. All other calls pass through to the wrapped client. The
+// retrier is shared with the rest of startup so fetches after the
+// deadline, for example when the config is reloaded, are only attempted
+// once.
+type retryingClient struct {
+	secrets.Client
+	retrier *startupRetrier
+}
+
+func (c *retryingClient) DatabaseCredential(ctx context.Context, path string) (cred *secrets.Credential, h secrets.Handle, err error) {
+	err = c.retrier.Do(ctx, "fetching database credential "+path, func() error {
+		cred, h, err = c.Client.DatabaseCredential(ctx, path)
+		return err
+	})
+	return
+}
+
+func (c *retryingClient) Secret(ctx context.Context, path string, out any) (h secrets.Handle, err error) {
+	err = c.retrier.Do(ctx, "fetching secret "+path, func() error {
+		h, err = c.Client.Secret(ctx, path, out)
+		return err
+	})
+	return
+}
+
+func (c *retryingClient) AWSIAMUser(ctx context.Context, name string) (cred *secrets.AWSCredential, h secrets.Handle, err error) {
+	err = c.retrier.Do(ctx, "fetching AWS IAM user "+name, func() error {
+		cred, h, err = c.Client.AWSIAMUser(ctx, name)
+		return err
+	})
+	return
+}
@@ -0,0 +1,132 @@
+package supervise
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"code.crute.us/mcrute/golib/secrets"
+	"github.com/stretchr/testify/assert"
+)
+
+func noopLogf(string, ...any) {}
+
+func TestStartupRetrierNoConfig(t *testing.T) {
+	calls := 0
+	r := newStartupRetrier(nil, noopLogf)
+	err := r.Do(context.TODO(), "test", func() error {
+		calls++
+		return fmt.Errorf("failed")
+	})
+	assert.ErrorContains(t, err, "failed")
+	assert.Equal(t, 1, calls)
+}
+
+func TestStartupRetrierDefaults(t *testing.T) {
+	r := newStartupRetrier(&RetryConfig{}, noopLogf)
+	assert.Equal(t, defaultRetryInitialBackoff, r.initial)
+	assert.Equal(t, defaultRetryMaxBackoff, r.max)
+	assert.WithinDuration(t, time.Now().Add(defaultRetryDeadline), r.deadline, time.Second)
+}
+
+func TestStartupRetrierSucceeds(t *testing.T) {
+	calls := 0
+	logs := 0
+	r := newStartupRetrier(&RetryConfig{
+		InitialBackoff: Duration(time.Millisecond),
+		MaxBackoff:     Duration(2 * time.Millisecond),
+		Deadline:       Duration(time.Second),
+	}, func(string, ...any) { logs++ })
+
+	err := r.Do(context.TODO(), "test", func() error {
+		if calls++; calls < 4 {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, calls)
+	assert.Equal(t, 3, logs)
+	assert.Equal(t, 2*time.Millisecond, r.max)
+}
+
+func TestStartupRetrierDeadline(t *testing.T) {
+	calls := 0
+	r := newStartupRetrier(&RetryConfig{
+		InitialBackoff: Duration(10 * time.Millisecond),
+		Deadline:       Duration(25 * time.Millisecond),
+	}, noopLogf)
+
+	err := r.Do(context.TODO(), "test", func() error {
+		calls++
+		return fmt.Errorf("failed %d", calls)
+	})
+	assert.ErrorContains(t, err, "failed 2")
+	assert.Equal(t, 2, calls)
+}
+
+func TestStartupRetrierCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+
+	calls := 0
+	r := newStartupRetrier(&RetryConfig{Deadline: Duration(time.Minute)}, noopLogf)
+	err := r.Do(ctx, "test", func() error {
+		calls++
+		return fmt.Errorf("failed")
+	})
+	assert.ErrorContains(t, err, "failed")
+	assert.Equal(t, 1, calls)
+}
+
+type flakySecretClient struct {
+	MockSecretClient
+	failures int
+}
+
+func (c *flakySecretClient) DatabaseCredential(ctx context.Context, path string) (*secrets.Credential, secrets.Handle, error) {
+	if c.failures > 0 {
+		c.failures--
+		return nil, nil, fmt.Errorf("vault unavailable")
+	}
+	return c.MockSecretClient.DatabaseCredential(ctx, path)
+}
+
+func TestRetryingClient(t *testing.T) {
+	sc := &flakySecretClient{failures: 2}
+	rc := &retryingClient{sc, newStartupRetrier(&RetryConfig{
+		InitialBackoff: Duration(time.Millisecond),
+		Deadline:       Duration(time.Second),
+	}, noopLogf)}
+
+	r, err := expandReplacements(context.TODO(), rc, map[string]string{"USER": "db:path:Username"}, []string{"USER"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"USER": "user1"}, r)
+	assert.Equal(t, 0, sc.failures)
+}
+
+func TestStartupRetrierPermanentError(t *testing.T) {
+	r := newStartupRetrier(&RetryConfig{
+		InitialBackoff: Duration(time.Millisecond),
+		Deadline:       Duration(time.Second),
+	}, noopLogf)
+
+	for err, want := range map[error]int{
+		&vaultResponseError{StatusCode: 403, Errors: []string{"permission denied"}}:                         1,
+		fmt.Errorf("login: %w", &vaultResponseError{StatusCode: 400}):                                       1,
+		fmt.Errorf("Error making API request.\n\nURL: GET https://vault/v1/kv/app\nCode: 404. Errors:\n\n"): 1,
+		&vaultResponseError{StatusCode: 429}:                                                                3,
+		&vaultResponseError{StatusCode: 503}:                                                                3,
+		fmt.Errorf("connection refused"):                                                                    3,
+	} {
+		calls := 0
+		r.Do(context.TODO(), "test", func() error {
+			if calls++; calls < 3 {
+				return err
+			}
+			return nil
+		})
+		assert.Equal(t, want, calls, err.Error())
+	}
+}
@@ -0,0 +1,132 @@
+package supervise
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const rlimitUnlimited = "unlimited"
+
+// Resource limits that may be set for jobs, named as in ulimit and
+// limits.conf
+var rlimitResources = map[string]int{
+	"as":      unix.RLIMIT_AS,
+	"core":    unix.RLIMIT_CORE,
+	"cpu":     unix.RLIMIT_CPU,
+	"memlock": unix.RLIMIT_MEMLOCK,
+	"nofile":  unix.RLIMIT_NOFILE,
+	"nproc":   unix.RLIMIT_NPROC,
+	"stack":   unix.RLIMIT_STACK,
+}
+
+// Rlimit is a soft and hard resource limit. In config files a limit is
+// either a number, which sets both limits, "unlimited", or a string of
+// the form "soft:hard" where each is a number or "unlimited".
+type Rlimit struct {
+	Soft uint64
+	Hard uint64
+}
+
+func (r *Rlimit) UnmarshalJSON(d []byte) error {
+	var n uint64
+	if err := json.Unmarshal(d, &n); err == nil {
+		r.Soft, r.Hard = n, n
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(d, &s); err != nil {
+		return fmt.Errorf("Rlimit.UnmarshalJSON: limit must be a number or string")
+	}
+
+	soft, hard, ok := strings.Cut(s, ":")
+	if !ok {
+		hard = soft
+	}
+
+	var err error
+	if r.Soft, err = parseRlimitValue(soft); err != nil {
+		return err
+	}
+	if r.Hard, err = parseRlimitValue(hard); err != nil {
+		return err
+	}
+	if r.Soft > r.Hard {
+		return fmt.Errorf("Rlimit.UnmarshalJSON: soft limit %s exceeds hard limit %s", soft, hard)
+	}
+
+	return nil
+}
+
+func (r Rlimit) MarshalJSON() ([]byte, error) {
+	if r.Soft == r.Hard && r.Soft != unix.RLIM_INFINITY {
+		return json.Marshal(r.Soft)
+	}
+	return json.Marshal(formatRlimitValue(r.Soft) + ":" + formatRlimitValue(r.Hard))
+}
+
+func formatRlimitValue(v uint64) string {
+	if v == unix.RLIM_INFINITY {
+		return rlimitUnlimited
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+func parseRlimitValue(v string) (uint64, error) {
+	if v == rlimitUnlimited {
+		return unix.RLIM_INFINITY, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Rlimit.UnmarshalJSON: invalid limit %s", v)
+	}
+	return n, nil
+}
+
+// validateRlimits returns an error if any of the limit names are not
+// supported
+func validateRlimits(limits map[string]Rlimit) error {
+	for name := range limits {
+		if _, ok := rlimitResources[name]; !ok {
+			valid := make([]string, 0, len(rlimitResources))
+			for k := range rlimitResources {
+				valid = append(valid, k)
+			}
+			sort.Strings(valid)
+			return fmt.Errorf("invalid rlimit %s, must be one of %s", name, strings.Join(valid, ", "))
+		}
+	}
+	return nil
+}
+
+// applyRlimits sets the resource limits of the current process. This
+// must be called before dropping privileges because raising a hard
+// limit requires CAP_SYS_RESOURCE.
+func applyRlimits(limits map[string]Rlimit) (string, error) {
+	names := make([]string, 0, len(limits))
+	for k := range limits {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		resource, ok := rlimitResources[name]
+		if !ok {
+			return name, fmt.Errorf("invalid rlimit")
+		}
+		l := limits[name]
+		// syscall.Setrlimit, unlike unix.Setrlimit, prevents the runtime
+		// from restoring its original nofile limit on exec
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: l.Soft, Max: l.Hard}); err != nil {
+			return name, err
+		}
+	}
+
+	return "", nil
+}
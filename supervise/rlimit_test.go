@@ -0,0 +1,61 @@
+package supervise
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestRlimitUnmarshal(t *testing.T) {
+	for in, out := range map[string]Rlimit{
+		`1024`:                  {1024, 1024},
+		`"1024"`:                {1024, 1024},
+		`"unlimited"`:           {unix.RLIM_INFINITY, unix.RLIM_INFINITY},
+		`"1024:4096"`:           {1024, 4096},
+		`"1024:unlimited"`:      {1024, unix.RLIM_INFINITY},
+		`"unlimited:unlimited"`: {unix.RLIM_INFINITY, unix.RLIM_INFINITY},
+	} {
+		r := Rlimit{}
+		assert.NoError(t, json.Unmarshal([]byte(in), &r), in)
+		assert.Equal(t, out, r, in)
+	}
+
+	for in, msg := range map[string]string{
+		`-1`:               "must be a number or string",
+		`"lots"`:           "invalid limit lots",
+		`"4096:1024"`:      "soft limit 4096 exceeds hard limit 1024",
+		`"unlimited:1024"`: "soft limit unlimited exceeds hard limit 1024",
+		`"1:2:3"`:          "invalid limit 2:3",
+	} {
+		r := Rlimit{}
+		assert.ErrorContains(t, json.Unmarshal([]byte(in), &r), msg, in)
+	}
+}
+
+func TestRlimitRoundTrip(t *testing.T) {
+	for _, in := range []Rlimit{
+		{1024, 1024},
+		{1024, 4096},
+		{0, unix.RLIM_INFINITY},
+		{unix.RLIM_INFINITY, unix.RLIM_INFINITY},
+	} {
+		b, err := json.Marshal(in)
+		assert.NoError(t, err)
+
+		out := Rlimit{}
+		assert.NoError(t, json.Unmarshal(b, &out), string(b))
+		assert.Equal(t, in, out)
+	}
+}
+
+func TestUnmarshalCommandRlimits(t *testing.T) {
+	cmd := &Command{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"cmd": ["test"], "rlimits": {"nofile": "1024:4096", "core": 0}}`), &cmd))
+	assert.Equal(t, map[string]Rlimit{"nofile": {1024, 4096}, "core": {0, 0}}, cmd.Rlimits)
+
+	cmd = &Command{}
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"cmd": ["test"], "rlimits": {"files": 1}}`), &cmd),
+		"invalid rlimit files, must be one of as, core, cpu, memlock, nofile, nproc, stack")
+}
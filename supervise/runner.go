@@ -4,12 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"slices"
+	"strings"
 	"sync"
 	"syscall"
 
 	"code.crute.us/mcrute/simplevisor/supervise/logging"
+	"golang.org/x/sys/unix"
 )
 
 type controlMessage struct {
@@ -17,13 +21,64 @@ type controlMessage struct {
 	Environment []string
 	User        int
 	Group       int
+	Groups      []int
+	WorkDir     string
+	Umask       *uint32
+	Rlimits     map[string]Rlimit
+
+	// Capabilities is the capability set of the job, nil leaves the
+	// capabilities unchanged
+	Capabilities        []int
+	AmbientCapabilities []int
+	SecureBits          int
+	NoNewPrivs          bool
+
+	// Seccomp is the compiled seccomp filter, installed right before
+	// exec
+	Seccomp []unix.SockFilter
+
+	// Namespaces are the clone flags of the namespaces the child was
+	// started in
+	Namespaces uintptr
+	Hostname   string
+	Filesystem FilesystemConfig
+}
+
+// ChildError is sent by the child to the parent over the control socket
+// when a setup step, or exec itself, fails. The socket is close-on-exec
+// in the child so if the command is exec'd successfully the parent reads
+// EOF instead.
+type ChildError struct {
+	Step    string
+	Target  string `json:",omitempty"`
+	Errno   int    `json:",omitempty"`
+	Message string
+}
+
+func (e *ChildError) Error() string {
+	if e.Target != "" {
+		return fmt.Sprintf("%s %s: %s", e.Step, e.Target, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Step, e.Message)
+}
+
+func (e *ChildError) Unwrap() error {
+	if e.Errno != 0 {
+		return syscall.Errno(e.Errno)
+	}
+	return nil
 }
 
 type CommandHandle struct {
+	name           string
 	cmd            *exec.Cmd
 	killsig        syscall.Signal
+	signalMap      map[syscall.Signal]syscall.Signal
 	stdout, stderr *os.File
 	cancel         func()
+	cgroup         *jobCgroup
+	signalScope    string
+	exited         bool
 }
 
 func (h *CommandHandle) Cleanup() {
@@ -33,19 +88,101 @@ func (h *CommandHandle) Cleanup() {
 }
 
 func (h *CommandHandle) Terminate() error {
-	err := h.cmd.Process.Signal(h.killsig)
+	err := h.Stop(nil)
 	h.Cleanup()
 	return err
 }
 
+// Stop sends the kill signal to the job without waiting for it to exit.
+// If the job is stopped because the supervisor received sig, and the
+// signal map of the job maps sig to another signal, that signal is sent
+// instead of the kill signal. Mapping sig to ignore does not prevent the
+// job from being stopped. sig is nil for other stops.
+func (h *CommandHandle) Stop(sig os.Signal) error {
+	if h.exited {
+		return nil
+	}
+
+	killsig := h.killsig
+	if s, ok := sig.(syscall.Signal); ok {
+		if target := h.signalMap[s]; target != 0 {
+			killsig = target
+		}
+	}
+
+	return h.send(killsig)
+}
+
+// Signal forwards a signal to the job, applying the signal map of the job
 func (h *CommandHandle) Signal(sig os.Signal) error {
-	return h.cmd.Process.Signal(sig)
+	if h.exited {
+		return nil
+	}
+
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return h.cmd.Process.Signal(sig)
+	}
+
+	if target, ok := h.signalMap[s]; ok {
+		if target == 0 {
+			return nil
+		}
+		s = target
+	}
+
+	return h.send(s)
+}
+
+// send delivers a signal to the job according to its signal scope
+func (h *CommandHandle) send(sig syscall.Signal) error {
+	pid := h.Pid()
+	switch h.signalScope {
+	case SignalScopeGroup:
+		return syscall.Kill(-pid, sig)
+	case SignalScopeSession:
+		return signalSession(pid, sig)
+	default:
+		return syscall.Kill(pid, sig)
+	}
+}
+
+// KillStragglers kills any processes remaining in the process group of
+// the job, or the session if that is the signal scope of the job. It
+// should be called once the job has exited.
+func (h *CommandHandle) KillStragglers() {
+	// The job leads its own process group and session, their ids remain
+	// reserved while any members exist even after the job is reaped
+	syscall.Kill(-h.Pid(), syscall.SIGKILL)
+	if h.signalScope == SignalScopeSession {
+		signalSession(h.Pid(), syscall.SIGKILL)
+	}
 }
 
 func (h *CommandHandle) ExitCode() int {
 	return h.cmd.ProcessState.ExitCode()
 }
 
+// OOMKills returns the number of processes in the job killed by the OOM
+// killer. It is always zero for jobs without a cgroup.
+func (h *CommandHandle) OOMKills() int {
+	if h.cgroup == nil {
+		return 0
+	}
+	return h.cgroup.OOMKills()
+}
+
+// RemoveCgroup kills any processes remaining in the job cgroup and
+// removes it. It must only be called once the job has exited.
+func (h *CommandHandle) RemoveCgroup() error {
+	if h.cgroup == nil {
+		return nil
+	}
+	err := h.cgroup.Remove()
+	h.cgroup = nil
+	return err
+}
+
 func (h *CommandHandle) Pid() int {
 	return h.cmd.Process.Pid
 }
@@ -59,12 +196,28 @@ type CommandRunner struct {
 	BaseContext context.Context
 	WaitGroup   *sync.WaitGroup
 	Environment []string
+
+	// JobEnvironments overrides Environment for jobs with their own
+	// environment config
+	JobEnvironments map[*Command][]string
+
+	// Cgroups creates cgroups for jobs with a cgroup config, it may be
+	// nil if no jobs have one
+	Cgroups *CgroupManager
 }
 
 func (r *CommandRunner) Run(spec *Command) (*CommandHandle, error) {
+	namespaces, err := parseNamespaces(spec.Namespaces)
+	if err != nil {
+		return nil, fmt.Errorf("Run: %w", err)
+	}
+	if spec.needsMountNamespace() {
+		namespaces |= syscall.CLONE_NEWNS
+	}
+
 	ctx, cancel := context.WithCancel(r.BaseContext)
 
-	cmdR, cmdW := mustPipe()
+	cmdR, cmdW := mustSocketPair()
 	soR, soW := mustPipe()
 	seR, seW := mustPipe()
 
@@ -75,6 +228,10 @@ func (r *CommandRunner) Run(spec *Command) (*CommandHandle, error) {
 		Stdout:     soW,
 		Stderr:     seW,
 		ExtraFiles: []*os.File{cmdR},
+
+		// Namespaces are created when the child is started because a
+		// multi-threaded process can not enter a new mount namespace
+		SysProcAttr: &syscall.SysProcAttr{Cloneflags: namespaces},
 	}
 
 	go logging.ProcessLogHandler(ctx, r.WaitGroup, r.Logger, soR, spec.Name, logging.Stdout)
@@ -86,11 +243,13 @@ func (r *CommandRunner) Run(spec *Command) (*CommandHandle, error) {
 	}
 
 	hnd := &CommandHandle{
-		cmd:     cmd,
-		stdout:  soR,
-		stderr:  seR,
-		cancel:  cancel,
-		killsig: spec.KillSignal,
+		name:      spec.Name,
+		cmd:       cmd,
+		stdout:    soR,
+		stderr:    seR,
+		cancel:    cancel,
+		killsig:   spec.KillSignal,
+		signalMap: spec.SignalMap,
 	}
 
 	// Cleanup child fds
@@ -110,16 +269,153 @@ func (r *CommandRunner) Run(spec *Command) (*CommandHandle, error) {
 		return nil, fmt.Errorf("Run: unable to resolve gid: %w", err)
 	}
 
+	groups, err := getGroups(spec.RunAsUser, spec.Groups)
+	if err != nil {
+		hnd.Terminate()
+		return nil, fmt.Errorf("Run: unable to resolve supplementary groups: %w", err)
+	}
+
+	home, err := getHomeDir(spec.RunAsUser)
+	if err != nil {
+		hnd.Terminate()
+		return nil, fmt.Errorf("Run: unable to resolve home directory: %w", err)
+	}
+
+	var caps, ambient []int
+	if spec.Capabilities != nil {
+		available, err := permittedCapabilities()
+		if err != nil {
+			hnd.Terminate()
+			return nil, fmt.Errorf("Run: unable to read capabilities: %w", err)
+		}
+		if caps, ambient, err = spec.Capabilities.resolve(available); err != nil {
+			hnd.Terminate()
+			return nil, fmt.Errorf("Run: %w", err)
+		}
+	}
+
+	secureBits, err := parseSecureBits(spec.SecureBits)
+	if err != nil {
+		hnd.Terminate()
+		return nil, fmt.Errorf("Run: %w", err)
+	}
+
+	var filter []unix.SockFilter
+	if spec.Seccomp != "" {
+		if filter, err = seccompFilter(spec.Seccomp, uid, caps); err != nil {
+			hnd.Terminate()
+			return nil, fmt.Errorf("Run: unable to prepare seccomp profile: %w", err)
+		}
+	}
+
+	env := r.Environment
+	if jobEnv, ok := r.JobEnvironments[spec]; ok {
+		env = jobEnv
+	}
+
+	if !slices.ContainsFunc(env, func(v string) bool { return strings.HasPrefix(v, "HOME=") }) {
+		env = append(slices.Clip(env), "HOME="+home)
+	}
+
+	// The child blocks reading the control message so it is moved into
+	// the cgroup before it can exec the job
+	if spec.Cgroup != nil {
+		if err := r.joinCgroup(hnd, spec); err != nil {
+			hnd.Terminate()
+			hnd.Wait()
+			hnd.RemoveCgroup()
+			return nil, fmt.Errorf("Run: %w", err)
+		}
+	}
+
 	if err := json.NewEncoder(cmdW).Encode(controlMessage{
 		Command:     spec.Command,
-		Environment: r.Environment,
+		Environment: env,
 		User:        uid,
 		Group:       gid,
+		Groups:      groups,
+		WorkDir:     expandHome(spec.WorkDir, home),
+		Umask:       spec.Umask,
+		Rlimits:     spec.Rlimits,
+
+		Capabilities:        caps,
+		AmbientCapabilities: ambient,
+		SecureBits:          secureBits,
+		NoNewPrivs:          spec.NoNewPrivs,
+		Seccomp:             filter,
+		Namespaces:          namespaces,
+		Hostname:            spec.Hostname,
+		Filesystem:          spec.FilesystemConfig,
 	}); err != nil {
 		hnd.Terminate()
+		hnd.RemoveCgroup()
 		return nil, fmt.Errorf("Run: Error writing to subprocess: %w", err)
 	}
+
+	// Blocks until the child either reports an error or the socket is
+	// closed by a successful exec
+	childErr := &ChildError{}
+	err = json.NewDecoder(cmdW).Decode(childErr)
 	cmdW.Close()
+	switch {
+	case err == io.EOF:
+	case err != nil:
+		hnd.Terminate()
+		hnd.RemoveCgroup()
+		return nil, fmt.Errorf("Run: error reading subprocess status: %w", err)
+	default:
+		// The child exits after reporting the error
+		hnd.Wait()
+		hnd.Cleanup()
+		hnd.RemoveCgroup()
+		return nil, fmt.Errorf("Run: %w", childErr)
+	}
+
+	// The job is only in its own process group and session once it has
+	// been exec'd, until then signals must go to the child directly
+	hnd.signalScope = spec.SignalScope
 
 	return hnd, nil
 }
+
+// seccompFilter compiles the seccomp profile for a job. The rules of the
+// profile depend on the capabilities that the job will have.
+func seccompFilter(profile string, uid int, caps []int) ([]unix.SockFilter, error) {
+	var mask uint64
+	switch {
+	case caps != nil:
+		for _, c := range caps {
+			mask |= 1 << c
+		}
+	case uid == 0:
+		var err error
+		if mask, err = permittedCapabilities(); err != nil {
+			return nil, err
+		}
+	}
+
+	p, err := loadSeccompProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.compile(mask)
+}
+
+func (r *CommandRunner) joinCgroup(hnd *CommandHandle, spec *Command) error {
+	if r.Cgroups == nil {
+		return fmt.Errorf("cgroups are not enabled")
+	}
+
+	cg, err := r.Cgroups.Create(spec.Name, spec.Cgroup)
+	if err != nil {
+		return fmt.Errorf("unable to create cgroup: %w", err)
+	}
+	hnd.cgroup = cg
+
+	if err := cg.AddProcess(hnd.Pid()); err != nil {
+		return fmt.Errorf("unable to join cgroup: %w", err)
+	}
+
+	return nil
+}
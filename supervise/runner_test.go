@@ -0,0 +1,83 @@
+package supervise
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChildError(t *testing.T) {
+	err := &ChildError{Step: "exec", Target: "/usr/bin/foo", Errno: int(syscall.ENOENT), Message: "no such file or directory"}
+	assert.Equal(t, "exec /usr/bin/foo: no such file or directory", err.Error())
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	assert.True(t, errors.Is(err, syscall.ENOENT))
+
+	err = &ChildError{Step: "setsid", Message: "failed"}
+	assert.Equal(t, "setsid: failed", err.Error())
+	assert.Nil(t, err.Unwrap())
+}
+
+func TestChildErrorRoundTrip(t *testing.T) {
+	in := &ChildError{Step: "setuid", Target: "1000", Errno: int(syscall.EPERM), Message: "operation not permitted"}
+	b, err := json.Marshal(in)
+	assert.NoError(t, err)
+
+	out := &ChildError{}
+	assert.NoError(t, json.Unmarshal(b, out))
+	assert.Equal(t, in, out)
+	assert.True(t, errors.Is(out, syscall.EPERM))
+}
+
+func TestCommandHandleSignalMap(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	assert.NoError(t, cmd.Start())
+
+	hnd := &CommandHandle{
+		cmd: cmd,
+		signalMap: map[syscall.Signal]syscall.Signal{
+			syscall.SIGTERM: 0,
+			syscall.SIGHUP:  syscall.SIGKILL,
+		},
+	}
+
+	// Ignored, sleep would otherwise exit
+	assert.NoError(t, hnd.Signal(syscall.SIGTERM))
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, cmd.Process.Signal(syscall.Signal(0)))
+
+	assert.NoError(t, hnd.Signal(syscall.SIGHUP))
+	cmd.Wait()
+	assert.Equal(t, syscall.SIGKILL, cmd.ProcessState.Sys().(syscall.WaitStatus).Signal())
+}
+
+func TestCommandHandleStopSignalMap(t *testing.T) {
+	stop := func(sig os.Signal) syscall.Signal {
+		cmd := exec.Command("sleep", "10")
+		assert.NoError(t, cmd.Start())
+
+		hnd := &CommandHandle{
+			cmd:     cmd,
+			killsig: syscall.SIGKILL,
+			signalMap: map[syscall.Signal]syscall.Signal{
+				syscall.SIGTERM: syscall.SIGQUIT,
+				syscall.SIGINT:  0,
+			},
+		}
+		assert.NoError(t, hnd.Stop(sig))
+		cmd.Wait()
+		return cmd.ProcessState.Sys().(syscall.WaitStatus).Signal()
+	}
+
+	assert.Equal(t, syscall.SIGQUIT, stop(syscall.SIGTERM))
+	// Ignoring the signal still stops the job
+	assert.Equal(t, syscall.SIGKILL, stop(syscall.SIGINT))
+	assert.Equal(t, syscall.SIGKILL, stop(syscall.SIGHUP))
+	assert.Equal(t, syscall.SIGKILL, stop(nil))
+}
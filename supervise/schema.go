@@ -0,0 +1,190 @@
+package supervise
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"syscall"
+)
+
+//go:generate go run ../generate_schema/main.go
+
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaType returns the schema of types with custom JSON decoding that
+// are not described by their config shape
+func schemaType(t reflect.Type, defs map[string]any) (map[string]any, bool) {
+	switch t {
+	case reflect.TypeFor[Duration]():
+		return map[string]any{"type": "string", "examples": []string{"30s", "1m30s"}}, true
+	case reflect.TypeFor[Rlimit]():
+		return map[string]any{"oneOf": []any{
+			map[string]any{"type": "integer", "minimum": 0},
+			map[string]any{"type": "string", "pattern": "^(unlimited|[0-9]+)(:(unlimited|[0-9]+))?$"},
+		}}, true
+	case reflect.TypeFor[SignalAction]():
+		actions := []string{SignalActionShutdown, SignalActionReload, SignalActionRestart, SignalActionForward, SignalActionIgnore}
+		return map[string]any{"oneOf": []any{
+			map[string]any{"enum": actions},
+			map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"action": map[string]any{"enum": actions},
+					"jobs":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+				},
+				"required":             []string{"action"},
+				"additionalProperties": false,
+			},
+		}}, true
+	case reflect.TypeFor[SignalConfig]():
+		names := slices.DeleteFunc(signalNames(), func(n string) bool {
+			return signalMap[n] == syscall.SIGKILL || signalMap[n] == syscall.SIGSTOP
+		})
+		return map[string]any{
+			"type":                 "object",
+			"propertyNames":        map[string]any{"enum": names},
+			"additionalProperties": configSchema(reflect.TypeFor[SignalAction](), defs),
+		}, true
+	}
+	return nil, false
+}
+
+// schemaField returns the schema of fields, by struct and field name,
+// that only accept some values. The fields of Command are declared by
+// commandAlias in its config shape.
+func schemaField(key string, defs map[string]any) (map[string]any, bool) {
+	switch key {
+	case "commandConfig.KillSig":
+		return map[string]any{"enum": signalNames()}, true
+	case "commandConfig.SignalMap":
+		return map[string]any{
+			"type":                 "object",
+			"propertyNames":        map[string]any{"enum": signalNames()},
+			"additionalProperties": map[string]any{"enum": append(signalNames(), signalIgnore)},
+		}, true
+	case "commandConfig.RunAs":
+		return map[string]any{"type": "string", "pattern": "^[^:]*(:[^:]*)?$"}, true
+	case "commandConfig.Umask":
+		return map[string]any{"type": "string", "pattern": "^0*[0-7]{1,3}$"}, true
+	case "commandAlias.Rlimits":
+		return map[string]any{
+			"type":                 "object",
+			"propertyNames":        map[string]any{"enum": slices.Sorted(maps.Keys(rlimitResources))},
+			"additionalProperties": configSchema(reflect.TypeFor[Rlimit](), defs),
+		}, true
+	case "commandAlias.Namespaces":
+		return map[string]any{
+			"type":  "array",
+			"items": map[string]any{"enum": slices.Sorted(maps.Keys(namespaceFlags))},
+		}, true
+	case "commandAlias.SignalScope":
+		return map[string]any{"enum": []string{SignalScopeProcess, SignalScopeGroup, SignalScopeSession}}, true
+	}
+	return nil, false
+}
+
+// signalNames returns the names of all signals, without the SIG prefix
+func signalNames() []string {
+	return slices.Sorted(maps.Keys(signalMap))
+}
+
+// ConfigSchema returns a JSON Schema for config files, including the
+// documentation of each field. Editors can use it to complete and check
+// configs.
+func ConfigSchema() ([]byte, error) {
+	defs := map[string]any{}
+	root := schemaObject(reflect.TypeFor[AppConfig](), defs)
+	root["$schema"] = schemaDialect
+	root["title"] = "simplevisor config"
+	root["$defs"] = defs
+
+	b, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("ConfigSchema: %w", err)
+	}
+	return b, nil
+}
+
+// configSchema returns the schema for values of type t. Named structs and
+// types with a schemaType are added to defs and referenced so that each is
+// only described once.
+func configSchema(t reflect.Type, defs map[string]any) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	shape := t
+	if s, ok := configShapes[t]; ok {
+		shape = s
+	}
+
+	s, custom := schemaType(t, defs)
+	if custom || (shape.Kind() == reflect.Struct && t.Name() != "") {
+		if _, ok := defs[t.Name()]; !ok {
+			// Placeholder for recursive types
+			defs[t.Name()] = nil
+			if !custom {
+				s = schemaObject(shape, defs)
+			}
+			defs[t.Name()] = schemaDoc(s, configDocs[t.Name()])
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	}
+
+	switch shape.Kind() {
+	case reflect.Struct:
+		return schemaObject(shape, defs)
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": configSchema(shape.Elem(), defs)}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": configSchema(shape.Elem(), defs)}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
+
+// schemaObject returns the schema of a struct, which like configs does
+// not allow unknown fields
+func schemaObject(t reflect.Type, defs map[string]any) map[string]any {
+	props := map[string]any{}
+	for name, f := range jsonFields(t) {
+		key := f.parent.Name() + "." + f.Name
+
+		s, ok := schemaField(key, defs)
+		if !ok {
+			s = configSchema(f.Type, defs)
+		}
+		props[name] = schemaDoc(s, configDocs[key])
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+// schemaDoc adds a description to a schema. References can not have
+// other keywords in older drafts of JSON Schema so they are wrapped.
+func schemaDoc(s map[string]any, doc string) map[string]any {
+	if doc == "" {
+		return s
+	}
+	if _, ok := s["$ref"]; ok {
+		return map[string]any{"allOf": []any{s}, "description": doc}
+	}
+	s["description"] = doc
+	return s
+}
@@ -0,0 +1,80 @@
+package supervise
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSchema(t *testing.T) map[string]any {
+	b, err := ConfigSchema()
+	assert.NoError(t, err)
+
+	var schema map[string]any
+	assert.NoError(t, json.Unmarshal(b, &schema))
+	return schema
+}
+
+// schemaPath returns the value at a path of keys in a schema
+func schemaPath(t *testing.T, v any, keys ...string) any {
+	for _, k := range keys {
+		m, ok := v.(map[string]any)
+		if !assert.True(t, ok, "%s is not an object", k) {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+func TestConfigSchema(t *testing.T) {
+	schema := testSchema(t)
+
+	assert.Equal(t, schemaDialect, schema["$schema"])
+	assert.Equal(t, false, schema["additionalProperties"])
+	assert.Equal(t, "#/$defs/JobsConfig", schemaPath(t, schema, "properties", "jobs", "allOf").([]any)[0].(map[string]any)["$ref"])
+	assert.Equal(t, "#/$defs/Command", schemaPath(t, schema, "$defs", "JobsConfig", "properties", "main", "items", "$ref"))
+
+	cmd := schemaPath(t, schema, "$defs", "Command", "properties").(map[string]any)
+	for _, field := range []string{"name", "cmd", "run-as", "kill-signal", "umask", "signal-map", "disabled", "chroot", "tmpfs"} {
+		assert.Contains(t, cmd, field)
+	}
+	for _, field := range []string{"RunAsUser", "RunAsGroup", "KillSignal", "SignalMap", "Umask", "FilesystemConfig"} {
+		assert.NotContains(t, cmd, field)
+	}
+
+	assert.Contains(t, schemaPath(t, cmd, "kill-signal", "enum"), "TERM")
+	assert.Contains(t, schemaPath(t, cmd, "signal-map", "additionalProperties", "enum"), "ignore")
+	assert.Equal(t, []any{"process", "group", "session"}, schemaPath(t, cmd, "signal-scope", "enum"))
+	assert.Contains(t, schemaPath(t, cmd, "namespaces", "items", "enum"), "pid")
+	assert.Equal(t, "#/$defs/Rlimit", schemaPath(t, cmd, "rlimits", "additionalProperties", "$ref"))
+
+	signals := schemaPath(t, schema, "$defs", "SignalConfig", "propertyNames", "enum")
+	assert.Contains(t, signals, "USR1")
+	assert.NotContains(t, signals, "KILL")
+	assert.NotContains(t, signals, "STOP")
+	assert.Contains(t, schema["$defs"], "SignalAction")
+}
+
+func TestConfigSchemaDocs(t *testing.T) {
+	schema := testSchema(t)
+
+	assert.Equal(t,
+		"Chroot is the root directory of the job.",
+		schemaPath(t, schema, "$defs", "Command", "properties", "chroot", "description"))
+	assert.Equal(t,
+		"RunAs is the user, or user:group, that the job runs as. Defaults to root.",
+		schemaPath(t, schema, "$defs", "Command", "properties", "run-as", "description"))
+	assert.Contains(t,
+		schemaPath(t, schema, "$defs", "Rlimit", "description"),
+		"soft and hard resource limit")
+	assert.Contains(t,
+		schemaPath(t, schema, "properties", "signals", "description"),
+		"Signals configures the action")
+}
+
+func TestReadAppConfigSchemaField(t *testing.T) {
+	_, err := ReadAppConfig(writeTestConfig(t, `{"$schema": "./simplevisor.schema.json"}`))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,408 @@
+package supervise
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The name of the built-in seccomp profile
+const seccompDefaultProfile = "default"
+
+// On x86_64 the x32 ABI shares the audit architecture and is
+// distinguished by this bit in the syscall number
+const seccompX32SyscallBit = 0x40000000
+
+var seccompAuditArches = map[string]uint32{
+	"386":     unix.AUDIT_ARCH_I386,
+	"amd64":   unix.AUDIT_ARCH_X86_64,
+	"arm":     unix.AUDIT_ARCH_ARM,
+	"arm64":   unix.AUDIT_ARCH_AARCH64,
+	"ppc64le": unix.AUDIT_ARCH_PPC64LE,
+	"riscv64": unix.AUDIT_ARCH_RISCV64,
+	"s390x":   unix.AUDIT_ARCH_S390X,
+}
+
+// Names used for the native architecture in the arches of profile
+// includes and excludes, in addition to GOARCH
+var seccompArchAliases = map[string]string{
+	"386": "x86",
+}
+
+// seccompBlockedSyscalls are denied by the built-in default profile. They
+// are the syscalls blocked by the Docker default profile for containers
+// without additional capabilities.
+var seccompBlockedSyscalls = []string{
+	"acct", "add_key", "bpf", "clock_adjtime", "clock_settime",
+	"create_module", "delete_module", "finit_module", "get_kernel_syms",
+	"get_mempolicy", "init_module", "ioperm", "iopl", "kcmp",
+	"kexec_file_load", "kexec_load", "keyctl", "lookup_dcookie", "mbind",
+	"mount", "move_pages", "name_to_handle_at", "nfsservctl",
+	"open_by_handle_at", "perf_event_open", "pivot_root",
+	"process_vm_readv", "process_vm_writev", "ptrace", "query_module",
+	"quotactl", "reboot", "request_key", "set_mempolicy", "setns",
+	"settimeofday", "stime", "swapoff", "swapon", "sysfs", "_sysctl",
+	"umount", "umount2", "unshare", "uselib", "userfaultfd", "ustat",
+	"vm86", "vm86old",
+}
+
+// seccompProfile is a Docker/OCI compatible seccomp profile. The
+// architectures of the profile are ignored, only the native architecture
+// is allowed.
+type seccompProfile struct {
+	DefaultAction   string           `json:"defaultAction"`
+	DefaultErrnoRet *uint32          `json:"defaultErrnoRet"`
+	Syscalls        []seccompSyscall `json:"syscalls"`
+}
+
+type seccompSyscall struct {
+	Name     string         `json:"name"`
+	Names    []string       `json:"names"`
+	Action   string         `json:"action"`
+	ErrnoRet *uint32        `json:"errnoRet"`
+	Args     []seccompArg   `json:"args"`
+	Includes seccompFilters `json:"includes"`
+	Excludes seccompFilters `json:"excludes"`
+}
+
+type seccompArg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo"`
+	Op       string `json:"op"`
+}
+
+type seccompFilters struct {
+	Arches    []string `json:"arches"`
+	Caps      []string `json:"caps"`
+	MinKernel string   `json:"minKernel"`
+}
+
+// loadSeccompProfile loads the built-in default profile or a JSON
+// profile from a file
+func loadSeccompProfile(name string) (*seccompProfile, error) {
+	if name == seccompDefaultProfile {
+		return &seccompProfile{
+			DefaultAction: "SCMP_ACT_ALLOW",
+			Syscalls: []seccompSyscall{{
+				Names:  seccompBlockedSyscalls,
+				Action: "SCMP_ACT_ERRNO",
+			}},
+		}, nil
+	}
+
+	fd, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("loadSeccompProfile: %w", err)
+	}
+	defer fd.Close()
+
+	p := &seccompProfile{}
+	if err := json.NewDecoder(fd).Decode(p); err != nil {
+		return nil, fmt.Errorf("loadSeccompProfile: error parsing %s: %w", name, err)
+	}
+
+	return p, nil
+}
+
+// compile builds a BPF filter program for the profile. caps are the
+// capabilities of the job and are used to evaluate the includes and
+// excludes of each rule. Syscalls that do not exist on the native
+// architecture are ignored.
+func (p *seccompProfile) compile(caps uint64) ([]unix.SockFilter, error) {
+	arch, ok := seccompAuditArches[runtime.GOARCH]
+	if !ok {
+		return nil, fmt.Errorf("seccomp is not supported on %s", runtime.GOARCH)
+	}
+
+	defaultAction, err := seccompAction(p.DefaultAction, p.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+
+	prog := []unix.SockFilter{
+		bpfLoad(unsafe.Offsetof(seccompData{}.Arch)),
+		bpfJump(unix.BPF_JEQ, arch, 1, 0),
+		bpfRet(unix.SECCOMP_RET_KILL_PROCESS),
+		bpfLoad(unsafe.Offsetof(seccompData{}.Nr)),
+	}
+	if runtime.GOARCH == "amd64" {
+		prog = append(prog,
+			bpfJump(unix.BPF_JGE, seccompX32SyscallBit, 0, 1),
+			bpfRet(unix.SECCOMP_RET_KILL_PROCESS),
+		)
+	}
+
+	for _, sc := range p.Syscalls {
+		if !sc.applies(caps) {
+			continue
+		}
+
+		action, err := seccompAction(sc.Action, sc.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+
+		names := sc.Names
+		if sc.Name != "" {
+			names = append(slices.Clip(names), sc.Name)
+		}
+
+		for _, name := range names {
+			nr, ok := syscallMap[name]
+			if !ok {
+				continue
+			}
+			block, err := seccompRule(uint32(nr), sc.Args, action)
+			if err != nil {
+				return nil, fmt.Errorf("syscall %s: %w", name, err)
+			}
+			prog = append(prog, block...)
+		}
+	}
+
+	prog = append(prog, bpfRet(defaultAction))
+
+	if len(prog) > unix.BPF_MAXINSNS {
+		return nil, fmt.Errorf("seccomp profile is too large (%d instructions)", len(prog))
+	}
+
+	return prog, nil
+}
+
+// applies reports whether a rule with the includes and excludes applies
+// to the job. Every include must match and no exclude may match.
+func (sc *seccompSyscall) applies(caps uint64) bool {
+	inc, exc := &sc.Includes, &sc.Excludes
+
+	if len(inc.Arches) > 0 && !inc.hasNativeArch() {
+		return false
+	}
+	if len(exc.Arches) > 0 && exc.hasNativeArch() {
+		return false
+	}
+
+	hasCap := func(name string) bool {
+		// Unknown capabilities are treated as not held
+		c, err := parseCapabilities([]string{name})
+		return err == nil && c&caps == c
+	}
+	for _, c := range inc.Caps {
+		if !hasCap(c) {
+			return false
+		}
+	}
+	for _, c := range exc.Caps {
+		if hasCap(c) {
+			return false
+		}
+	}
+
+	if inc.MinKernel != "" && !kernelVersionAtLeast(inc.MinKernel) {
+		return false
+	}
+	if exc.MinKernel != "" && kernelVersionAtLeast(exc.MinKernel) {
+		return false
+	}
+
+	return true
+}
+
+func (f *seccompFilters) hasNativeArch() bool {
+	return slices.Contains(f.Arches, runtime.GOARCH) || slices.Contains(f.Arches, seccompArchAliases[runtime.GOARCH])
+}
+
+func seccompAction(name string, errno *uint32) (uint32, error) {
+	switch name {
+	case "SCMP_ACT_ALLOW":
+		return unix.SECCOMP_RET_ALLOW, nil
+	case "SCMP_ACT_ERRNO":
+		ret := uint32(unix.EPERM)
+		if errno != nil {
+			ret = *errno
+		}
+		return unix.SECCOMP_RET_ERRNO | (ret & unix.SECCOMP_RET_DATA), nil
+	case "SCMP_ACT_KILL", "SCMP_ACT_KILL_THREAD":
+		return unix.SECCOMP_RET_KILL_THREAD, nil
+	case "SCMP_ACT_KILL_PROCESS":
+		return unix.SECCOMP_RET_KILL_PROCESS, nil
+	case "SCMP_ACT_TRAP":
+		return unix.SECCOMP_RET_TRAP, nil
+	case "SCMP_ACT_LOG":
+		return unix.SECCOMP_RET_LOG, nil
+	default:
+		return 0, fmt.Errorf("unsupported seccomp action %q", name)
+	}
+}
+
+// seccompData mirrors struct seccomp_data, it is used only for offsets
+type seccompData struct {
+	Nr                 int32
+	Arch               uint32
+	InstructionPointer uint64
+	Args               [6]uint64
+}
+
+// Jump target within a rule for the start of the next rule, it is
+// resolved once the rule is assembled
+const bpfNextRule = -1
+
+type bpfRuleInsn struct {
+	unix.SockFilter
+	jt, jf int
+}
+
+// seccompRule assembles the instructions for a single syscall rule. The
+// accumulator holds the syscall number on entry and on exit if the rule
+// does not match.
+func seccompRule(nr uint32, args []seccompArg, action uint32) ([]unix.SockFilter, error) {
+	insns := []bpfRuleInsn{{bpfJump(unix.BPF_JEQ, nr, 0, 0), 0, bpfNextRule}}
+
+	for _, a := range args {
+		if a.Index >= 6 {
+			return nil, fmt.Errorf("invalid argument index %d", a.Index)
+		}
+		cond, err := seccompArgCondition(a)
+		if err != nil {
+			return nil, err
+		}
+		insns = append(insns, cond...)
+	}
+
+	insns = append(insns, bpfRuleInsn{bpfRet(action), 0, 0})
+	if len(args) > 0 {
+		// Argument checks overwrite the syscall number
+		insns = append(insns, bpfRuleInsn{bpfLoad(unsafe.Offsetof(seccompData{}.Nr)), 0, 0})
+	}
+
+	out := make([]unix.SockFilter, len(insns))
+	for i, insn := range insns {
+		resolve := func(target int) uint8 {
+			if target == bpfNextRule {
+				// Skip to the reload of the syscall number, or past the
+				// return if there are no argument checks
+				end := len(insns) - 1
+				if len(args) == 0 {
+					end = len(insns)
+				}
+				return uint8(end - i - 1)
+			}
+			return uint8(target)
+		}
+		out[i] = insn.SockFilter
+		out[i].Jt = resolve(insn.jt)
+		out[i].Jf = resolve(insn.jf)
+	}
+
+	return out, nil
+}
+
+// seccompArgCondition compares a 64-bit syscall argument using the high
+// and low 32-bit words, jumping to the next rule if the condition fails
+// and falling through if it passes
+func seccompArgCondition(a seccompArg) ([]bpfRuleInsn, error) {
+	off := unsafe.Offsetof(seccompData{}.Args) + uintptr(a.Index)*8
+	loOff, hiOff := off, off+4
+	if binary.NativeEndian.Uint16([]byte{0, 1}) == 1 {
+		loOff, hiOff = off+4, off
+	}
+
+	ld := func(o uintptr) bpfRuleInsn { return bpfRuleInsn{bpfLoad(o), 0, 0} }
+	jmp := func(op uint16, k uint32, jt, jf int) bpfRuleInsn { return bpfRuleInsn{bpfJump(op, k, 0, 0), jt, jf} }
+	hi, lo := uint32(a.Value>>32), uint32(a.Value)
+	fail := bpfNextRule
+
+	switch a.Op {
+	case "SCMP_CMP_EQ":
+		return []bpfRuleInsn{
+			ld(hiOff), jmp(unix.BPF_JEQ, hi, 0, fail),
+			ld(loOff), jmp(unix.BPF_JEQ, lo, 0, fail),
+		}, nil
+	case "SCMP_CMP_NE":
+		return []bpfRuleInsn{
+			ld(hiOff), jmp(unix.BPF_JEQ, hi, 0, 2),
+			ld(loOff), jmp(unix.BPF_JEQ, lo, fail, 0),
+		}, nil
+	case "SCMP_CMP_GT", "SCMP_CMP_GE":
+		op := uint16(unix.BPF_JGT)
+		if a.Op == "SCMP_CMP_GE" {
+			op = unix.BPF_JGE
+		}
+		return []bpfRuleInsn{
+			ld(hiOff), jmp(unix.BPF_JGT, hi, 3, 0), jmp(unix.BPF_JEQ, hi, 0, fail),
+			ld(loOff), jmp(op, lo, 0, fail),
+		}, nil
+	case "SCMP_CMP_LT", "SCMP_CMP_LE":
+		op := uint16(unix.BPF_JGE)
+		if a.Op == "SCMP_CMP_LE" {
+			op = unix.BPF_JGT
+		}
+		return []bpfRuleInsn{
+			ld(hiOff), jmp(unix.BPF_JGT, hi, fail, 0), jmp(unix.BPF_JEQ, hi, 0, 2),
+			ld(loOff), jmp(op, lo, fail, 0),
+		}, nil
+	case "SCMP_CMP_MASKED_EQ":
+		and := func(k uint32) bpfRuleInsn {
+			return bpfRuleInsn{unix.SockFilter{Code: unix.BPF_ALU | unix.BPF_AND | unix.BPF_K, K: k}, 0, 0}
+		}
+		vhi, vlo := uint32(a.ValueTwo>>32), uint32(a.ValueTwo)
+		return []bpfRuleInsn{
+			ld(hiOff), and(hi), jmp(unix.BPF_JEQ, vhi, 0, fail),
+			ld(loOff), and(lo), jmp(unix.BPF_JEQ, vlo, 0, fail),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported seccomp operator %q", a.Op)
+	}
+}
+
+func bpfLoad(off uintptr) unix.SockFilter {
+	return unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: uint32(off)}
+}
+
+func bpfJump(op uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: unix.BPF_JMP | op | unix.BPF_K, Jt: jt, Jf: jf, K: k}
+}
+
+func bpfRet(k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: k}
+}
+
+// kernelVersionAtLeast reports whether the running kernel is at least
+// version v, in the form major.minor
+func kernelVersionAtLeast(v string) bool {
+	uts := unix.Utsname{}
+	if err := unix.Uname(&uts); err != nil {
+		return false
+	}
+	return compareKernelVersions(unix.ByteSliceToString(uts.Release[:]), v) >= 0
+}
+
+// compareKernelVersions compares the major and minor versions of kernel
+// releases such as 6.1.0-13-amd64
+func compareKernelVersions(a, b string) int {
+	parse := func(v string) []int {
+		out := []int{}
+		for _, p := range strings.SplitN(v, ".", 3)[:min(2, strings.Count(v, ".")+1)] {
+			p = strings.TrimRightFunc(p, func(r rune) bool { return r < '0' || r > '9' })
+			n, _ := strconv.Atoi(p)
+			out = append(out, n)
+		}
+		return out
+	}
+	return slices.Compare(parse(a), parse(b))
+}
+
+// installSeccomp installs the filter for the calling thread. The caller
+// must have set no_new_privs.
+func installSeccomp(filter []unix.SockFilter) error {
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	return unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0)
+}
@@ -0,0 +1,181 @@
+package supervise
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// runSeccompFilter evaluates a filter for a syscall on the native
+// architecture
+func runSeccompFilter(t *testing.T, prog []unix.SockFilter, nr int, args ...uint64) uint32 {
+	data := make([]byte, 64)
+	binary.NativeEndian.PutUint32(data[0:], uint32(nr))
+	binary.NativeEndian.PutUint32(data[4:], seccompAuditArches[runtime.GOARCH])
+	for i, a := range args {
+		binary.NativeEndian.PutUint64(data[16+8*i:], a)
+	}
+
+	var acc uint32
+	for pc := 0; pc < len(prog); pc++ {
+		insn := prog[pc]
+		switch insn.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			acc = binary.NativeEndian.Uint32(data[insn.K:])
+		case unix.BPF_ALU | unix.BPF_AND | unix.BPF_K:
+			acc &= insn.K
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, unix.BPF_JMP | unix.BPF_JGT | unix.BPF_K, unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			var match bool
+			switch insn.Code &^ (unix.BPF_JMP | unix.BPF_K) {
+			case unix.BPF_JEQ:
+				match = acc == insn.K
+			case unix.BPF_JGT:
+				match = acc > insn.K
+			case unix.BPF_JGE:
+				match = acc >= insn.K
+			}
+			if match {
+				pc += int(insn.Jt)
+			} else {
+				pc += int(insn.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return insn.K
+		default:
+			t.Fatalf("unexpected instruction %#v", insn)
+		}
+	}
+
+	t.Fatal("filter did not return")
+	return 0
+}
+
+func TestSeccompDefaultProfile(t *testing.T) {
+	p, err := loadSeccompProfile("default")
+	assert.NoError(t, err)
+
+	prog, err := p.compile(0)
+	assert.NoError(t, err)
+
+	eperm := uint32(unix.SECCOMP_RET_ERRNO | unix.EPERM)
+	assert.Equal(t, eperm, runSeccompFilter(t, prog, syscallMap["mount"]))
+	assert.Equal(t, eperm, runSeccompFilter(t, prog, syscallMap["ptrace"]))
+	assert.Equal(t, uint32(unix.SECCOMP_RET_ALLOW), runSeccompFilter(t, prog, syscallMap["read"]))
+	assert.Equal(t, uint32(unix.SECCOMP_RET_ALLOW), runSeccompFilter(t, prog, syscallMap["execve"]))
+}
+
+func TestSeccompProfileArgs(t *testing.T) {
+	p := &seccompProfile{
+		DefaultAction: "SCMP_ACT_ERRNO",
+		Syscalls: []seccompSyscall{
+			{Names: []string{"read", "write"}, Action: "SCMP_ACT_ALLOW"},
+			{Names: []string{"personality"}, Action: "SCMP_ACT_ALLOW", Args: []seccompArg{{Index: 0, Value: 0x20008, Op: "SCMP_CMP_EQ"}}},
+			{Names: []string{"personality"}, Action: "SCMP_ACT_ALLOW", Args: []seccompArg{{Index: 0, Value: 0xffffffff, Op: "SCMP_CMP_EQ"}}},
+			{Names: []string{"clone"}, Action: "SCMP_ACT_ALLOW", Args: []seccompArg{{Index: 0, Value: 0x7e020000, ValueTwo: 0, Op: "SCMP_CMP_MASKED_EQ"}}},
+			{Names: []string{"kill"}, Action: "SCMP_ACT_ALLOW", Args: []seccompArg{
+				{Index: 0, Value: 1 << 32, Op: "SCMP_CMP_LT"},
+				{Index: 1, Value: 9, Op: "SCMP_CMP_NE"},
+			}},
+			{Names: []string{"socket"}, Action: "SCMP_ACT_ALLOW", Args: []seccompArg{{Index: 0, Value: 10, Op: "SCMP_CMP_GE"}}},
+			{Names: []string{"socket"}, Action: "SCMP_ACT_ALLOW", Args: []seccompArg{{Index: 0, Value: 1, Op: "SCMP_CMP_LE"}}},
+			{Names: []string{"not_a_syscall"}, Action: "SCMP_ACT_ALLOW"},
+		},
+	}
+
+	prog, err := p.compile(0)
+	assert.NoError(t, err)
+
+	allow, deny := uint32(unix.SECCOMP_RET_ALLOW), uint32(unix.SECCOMP_RET_ERRNO|unix.EPERM)
+	for _, tc := range []struct {
+		name   string
+		args   []uint64
+		result uint32
+	}{
+		{"read", nil, allow},
+		{"write", nil, allow},
+		{"open", nil, deny},
+		{"personality", []uint64{0x20008}, allow},
+		{"personality", []uint64{0xffffffff}, allow},
+		{"personality", []uint64{0x1}, deny},
+		{"personality", []uint64{1<<32 | 0x20008}, deny},
+		{"clone", []uint64{uint64(unix.SIGCHLD)}, allow},
+		{"clone", []uint64{unix.CLONE_NEWNS | uint64(unix.SIGCHLD)}, deny},
+		{"kill", []uint64{100, 15}, allow},
+		{"kill", []uint64{100, 9}, deny},
+		{"kill", []uint64{1 << 32, 15}, deny},
+		{"socket", []uint64{unix.AF_INET6}, allow},
+		{"socket", []uint64{unix.AF_UNIX}, allow},
+		{"socket", []uint64{unix.AF_INET}, deny},
+		{"socket", []uint64{1 << 33}, allow},
+	} {
+		assert.Equal(t, tc.result, runSeccompFilter(t, prog, syscallMap[tc.name], tc.args...), "%s %v", tc.name, tc.args)
+	}
+}
+
+func TestSeccompProfileFilters(t *testing.T) {
+	p := &seccompProfile{
+		DefaultAction:   "SCMP_ACT_ERRNO",
+		DefaultErrnoRet: new(uint32),
+		Syscalls: []seccompSyscall{
+			{Names: []string{"mount"}, Action: "SCMP_ACT_ALLOW", Includes: seccompFilters{Caps: []string{"CAP_SYS_ADMIN"}}},
+			{Names: []string{"read"}, Action: "SCMP_ACT_ALLOW", Excludes: seccompFilters{Caps: []string{"CAP_SYS_ADMIN"}}},
+			{Names: []string{"write"}, Action: "SCMP_ACT_ALLOW", Includes: seccompFilters{Arches: []string{"not-an-arch"}}},
+			{Names: []string{"close"}, Action: "SCMP_ACT_ALLOW", Includes: seccompFilters{MinKernel: "999.0"}},
+		},
+	}
+	*p.DefaultErrnoRet = uint32(unix.ENOSYS)
+
+	deny := uint32(unix.SECCOMP_RET_ERRNO | unix.ENOSYS)
+
+	prog, err := p.compile(0)
+	assert.NoError(t, err)
+	assert.Equal(t, deny, runSeccompFilter(t, prog, syscallMap["mount"]))
+	assert.Equal(t, uint32(unix.SECCOMP_RET_ALLOW), runSeccompFilter(t, prog, syscallMap["read"]))
+	assert.Equal(t, deny, runSeccompFilter(t, prog, syscallMap["write"]))
+	assert.Equal(t, deny, runSeccompFilter(t, prog, syscallMap["close"]))
+
+	prog, err = p.compile(1 << unix.CAP_SYS_ADMIN)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(unix.SECCOMP_RET_ALLOW), runSeccompFilter(t, prog, syscallMap["mount"]))
+	assert.Equal(t, deny, runSeccompFilter(t, prog, syscallMap["read"]))
+}
+
+func TestSeccompProfileErrors(t *testing.T) {
+	for msg, p := range map[string]*seccompProfile{
+		`unsupported seccomp action "SCMP_ACT_NOPE"`:    {DefaultAction: "SCMP_ACT_NOPE"},
+		`unsupported seccomp operator "SCMP_CMP_ABOUT"`: {DefaultAction: "SCMP_ACT_ALLOW", Syscalls: []seccompSyscall{{Names: []string{"read"}, Action: "SCMP_ACT_ERRNO", Args: []seccompArg{{Op: "SCMP_CMP_ABOUT"}}}}},
+		"invalid argument index 6":                      {DefaultAction: "SCMP_ACT_ALLOW", Syscalls: []seccompSyscall{{Names: []string{"read"}, Action: "SCMP_ACT_ERRNO", Args: []seccompArg{{Index: 6, Op: "SCMP_CMP_EQ"}}}}},
+	} {
+		_, err := p.compile(0)
+		assert.ErrorContains(t, err, msg)
+	}
+}
+
+func TestLoadSeccompProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.json")
+	os.WriteFile(path, []byte(`{
+		"defaultAction": "SCMP_ACT_ERRNO",
+		"architectures": ["SCMP_ARCH_X86_64"],
+		"syscalls": [{"names": ["read"], "action": "SCMP_ACT_ALLOW", "args": []}]
+	}`), 0644)
+
+	p, err := loadSeccompProfile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "SCMP_ACT_ERRNO", p.DefaultAction)
+	assert.Equal(t, []string{"read"}, p.Syscalls[0].Names)
+
+	_, err = loadSeccompProfile(filepath.Join(t.TempDir(), "missing.json"))
+	assert.ErrorContains(t, err, "no such file")
+}
+
+func TestCompareKernelVersions(t *testing.T) {
+	assert.Equal(t, 0, compareKernelVersions("6.1.0-13-amd64", "6.1"))
+	assert.Equal(t, 1, compareKernelVersions("6.18.44-fc", "6.2"))
+	assert.Equal(t, -1, compareKernelVersions("4.19", "5.4"))
+	assert.Equal(t, 1, compareKernelVersions("5.10", "5"))
+}
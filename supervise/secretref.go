@@ -0,0 +1,219 @@
+package supervise
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const secretRefSchemePrefix = "vault+"
+
+// Maps the engine portion of a vault+engine:// reference to the secret
+// type names used by the legacy type:path:field format.
+var secretRefEngines = map[string]string{
+	"kv":       "secret",
+	"secret":   "secret",
+	"db":       "db",
+	"aws-user": "aws-user",
+}
+
+type fieldPart struct {
+	Key     string
+	Index   int
+	IsIndex bool
+}
+
+// secretRef is a parsed reference to a secret in Vault. References take
+// one of two forms, the legacy form type:path:field or the URI form
+// vault+engine://path?default=value&optional#field.sub[0]
+type secretRef struct {
+	Type       string
+	Path       string
+	Field      string
+	Default    string
+	HasDefault bool
+	Optional   bool
+	fieldPath  []fieldPart
+}
+
+func parseSecretId(name, id string) (*secretRef, error) {
+	if strings.HasPrefix(id, secretRefSchemePrefix) {
+		return parseSecretURI(name, id)
+	}
+
+	// The type can not contain a colon and neither can a field, but
+	// paths may so split from both ends
+	first, last := strings.Index(id, ":"), strings.LastIndex(id, ":")
+	if first < 0 || first == last {
+		return nil, fmt.Errorf("PrepareEnvironment: error parsing vault variable %s, not len(3)", name)
+	}
+
+	// Legacy fields are always a single literal key
+	field := id[last+1:]
+	return &secretRef{
+		Type:      id[:first],
+		Path:      id[first+1 : last],
+		Field:     field,
+		fieldPath: []fieldPart{{Key: field}},
+	}, nil
+}
+
+func parseSecretURI(name, id string) (*secretRef, error) {
+	engine, rest, ok := strings.Cut(strings.TrimPrefix(id, secretRefSchemePrefix), "://")
+	if !ok {
+		return nil, fmt.Errorf("PrepareEnvironment: error parsing vault variable %s, missing ://", name)
+	}
+
+	ref := &secretRef{Type: engine}
+	if t, ok := secretRefEngines[engine]; ok {
+		ref.Type = t
+	}
+
+	rest, ref.Field, _ = strings.Cut(rest, "#")
+	rawPath, rawQuery, _ := strings.Cut(rest, "?")
+
+	var err error
+	if ref.Path, err = url.PathUnescape(rawPath); err != nil {
+		return nil, fmt.Errorf("PrepareEnvironment: error parsing vault variable %s path: %w", name, err)
+	}
+	if ref.Path == "" {
+		return nil, fmt.Errorf("PrepareEnvironment: error parsing vault variable %s, empty path", name)
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("PrepareEnvironment: error parsing vault variable %s options: %w", name, err)
+	}
+	for k, v := range query {
+		switch k {
+		case "default":
+			ref.Default, ref.HasDefault = v[0], true
+		case "optional":
+			if v[0] != "" {
+				if ref.Optional, err = strconv.ParseBool(v[0]); err != nil {
+					return nil, fmt.Errorf("PrepareEnvironment: error parsing vault variable %s, invalid optional value %s", name, v[0])
+				}
+			} else {
+				ref.Optional = true
+			}
+		default:
+			return nil, fmt.Errorf("PrepareEnvironment: error parsing vault variable %s, unknown option %s", name, k)
+		}
+	}
+
+	// Credentials always have every field so a missing one is a typo
+	if (ref.HasDefault || ref.Optional) && (ref.Type == "db" || ref.Type == "aws-user") {
+		return nil, fmt.Errorf("PrepareEnvironment: error parsing vault variable %s, default and optional are only supported for kv secrets", name)
+	}
+
+	if ref.Field == "" {
+		return nil, fmt.Errorf("PrepareEnvironment: error parsing vault variable %s, missing #field", name)
+	}
+	if ref.fieldPath, err = parseFieldPath(ref.Field); err != nil {
+		return nil, fmt.Errorf("PrepareEnvironment: error parsing vault variable %s: %w", name, err)
+	}
+
+	// Keys are decoded after the field is split so that escaped dots and
+	// brackets are part of the key
+	for i, p := range ref.fieldPath {
+		if ref.fieldPath[i].Key, err = url.PathUnescape(p.Key); err != nil {
+			return nil, fmt.Errorf("PrepareEnvironment: error parsing vault variable %s field: %w", name, err)
+		}
+	}
+	if ref.Field, err = url.PathUnescape(ref.Field); err != nil {
+		return nil, fmt.Errorf("PrepareEnvironment: error parsing vault variable %s field: %w", name, err)
+	}
+
+	return ref, nil
+}
+
+// parseFieldPath parses a field path like foo.bar[0].baz into its
+// component map keys and list indexes.
+func parseFieldPath(p string) ([]fieldPart, error) {
+	parts := []fieldPart{}
+
+	for _, seg := range strings.Split(p, ".") {
+		key, idxs, hasIdx := strings.Cut(seg, "[")
+		if key != "" {
+			parts = append(parts, fieldPart{Key: key})
+		} else if !hasIdx {
+			return nil, fmt.Errorf("empty key in field %s", p)
+		}
+
+		if !hasIdx {
+			continue
+		}
+
+		for idxs = "[" + idxs; idxs != ""; {
+			end := strings.IndexByte(idxs, ']')
+			if idxs[0] != '[' || end < 0 {
+				return nil, fmt.Errorf("malformed index in field %s", p)
+			}
+			idx, err := strconv.Atoi(idxs[1:end])
+			if err != nil || idx < 0 {
+				return nil, fmt.Errorf("invalid index %s in field %s", idxs[1:end], p)
+			}
+			parts = append(parts, fieldPart{Index: idx, IsIndex: true})
+			idxs = idxs[end+1:]
+		}
+	}
+
+	return parts, nil
+}
+
+// lookup walks the field path of the reference through a decoded JSON
+// document. Missing keys, out of range indexes, and null values are all
+// reported as not found.
+func (r *secretRef) lookup(doc any) (any, bool) {
+	v := doc
+	for _, p := range r.fieldPath {
+		if p.IsIndex {
+			l, ok := v.([]any)
+			if !ok || p.Index >= len(l) {
+				return nil, false
+			}
+			v = l[p.Index]
+		} else {
+			m, ok := v.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[p.Key]; !ok {
+				return nil, false
+			}
+		}
+	}
+	return v, v != nil
+}
+
+// renderSecretValue converts a decoded JSON value into the text that
+// will be placed in the environment. Scalars are rendered as text and
+// objects and lists as compact JSON.
+func renderSecretValue(v any) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	case json.Number:
+		return t.String(), nil
+	default:
+		out, err := json.Marshal(t)
+		if err != nil {
+			return "", err
+		}
+		return string(out), nil
+	}
+}
+
+// isSecretNotFound returns true if err is from fetching a secret that
+// does not exist
+func isSecretNotFound(err error) bool {
+	code, ok := vaultStatusCode(err)
+	return ok && code == http.StatusNotFound
+}
@@ -0,0 +1,105 @@
+package supervise
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSecretURI(t *testing.T) {
+	r, err := parseSecretId("foo", "vault+kv://my/app:v2/creds#field.sub[0]")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", r.Type)
+	assert.Equal(t, "my/app:v2/creds", r.Path)
+	assert.Equal(t, "field.sub[0]", r.Field)
+	assert.False(t, r.HasDefault)
+	assert.False(t, r.Optional)
+	assert.Equal(t, []fieldPart{
+		{Key: "field"},
+		{Key: "sub"},
+		{Index: 0, IsIndex: true},
+	}, r.fieldPath)
+
+	r, err = parseSecretId("foo", "vault+kv://prod%23app?default=a%26b&optional=false#key")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", r.Type)
+	assert.Equal(t, "prod#app", r.Path)
+	assert.Equal(t, "a&b", r.Default)
+	assert.True(t, r.HasDefault)
+	assert.False(t, r.Optional)
+
+	r, err = parseSecretId("foo", "vault+kv://app?optional#key")
+	assert.NoError(t, err)
+	assert.True(t, r.Optional)
+
+	r, err = parseSecretId("foo", "vault+kv://app#tls%2Ecert.%5Bprimary%5D[1].a%20b")
+	assert.NoError(t, err)
+	assert.Equal(t, "tls.cert.[primary][1].a b", r.Field)
+	assert.Equal(t, []fieldPart{
+		{Key: "tls.cert"},
+		{Key: "[primary]"},
+		{Index: 1, IsIndex: true},
+		{Key: "a b"},
+	}, r.fieldPath)
+
+	r, err = parseSecretId("foo", "vault+aws-user://user#KeyId")
+	assert.NoError(t, err)
+	assert.Equal(t, "aws-user", r.Type)
+	assert.Equal(t, "user", r.Path)
+}
+
+func TestParseSecretURIErrors(t *testing.T) {
+	for id, msg := range map[string]string{
+		"vault+kv:path#foo":                    "missing ://",
+		"vault+kv://#foo":                      "empty path",
+		"vault+kv://path":                      "missing #field",
+		"vault+kv://path?bogus=1#foo":          "unknown option bogus",
+		"vault+kv://path?optional=x#foo":       "invalid optional value x",
+		"vault+kv://path#foo..bar":             "empty key in field foo..bar",
+		"vault+kv://path#foo[1":                "malformed index in field foo[1",
+		"vault+kv://path#foo[x]":               "invalid index x in field foo[x]",
+		"vault+kv://path#foo[0]bar":            "malformed index in field foo[0]bar",
+		"vault+kv://path#foo%zz":               "invalid URL escape",
+		"vault+db://path?default=x#Username":   "default and optional are only supported for kv secrets",
+		"vault+aws-user://path?optional#KeyId": "default and optional are only supported for kv secrets",
+	} {
+		_, err := parseSecretId("name", id)
+		assert.ErrorContains(t, err, msg, id)
+	}
+}
+
+func TestParseFieldPath(t *testing.T) {
+	p, err := parseFieldPath("a[1][2].b")
+	assert.NoError(t, err)
+	assert.Equal(t, []fieldPart{
+		{Key: "a"},
+		{Index: 1, IsIndex: true},
+		{Index: 2, IsIndex: true},
+		{Key: "b"},
+	}, p)
+
+	p, err = parseFieldPath("[0].a")
+	assert.NoError(t, err)
+	assert.Equal(t, []fieldPart{
+		{Index: 0, IsIndex: true},
+		{Key: "a"},
+	}, p)
+}
+
+func TestRenderSecretValue(t *testing.T) {
+	for in, out := range map[any]string{
+		"foo":         "foo",
+		true:          "true",
+		float64(1):    "1",
+		float64(1.5):  "1.5",
+		float64(1e21): "1000000000000000000000",
+	} {
+		v, err := renderSecretValue(in)
+		assert.NoError(t, err)
+		assert.Equal(t, out, v)
+	}
+
+	v, err := renderSecretValue(map[string]any{"a": []any{"b", float64(1)}})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":["b",1]}`, v)
+}
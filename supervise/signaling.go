@@ -1,14 +1,35 @@
 package supervise
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 )
 
-func SetupSignals() chan os.Signal {
+// Highest signal number on Linux
+const maxSignal = 64
+
+// SetupSignals returns a channel of the signals received by the
+// supervisor and a channel that is notified when children exit. SIGCHLD
+// is only sent to the child channel so that many exiting children can
+// not cause other signals to be dropped. The child channel holds a single
+// notification so notifications are coalesced, each one means that there
+// may be children to reap.
+func SetupSignals() (chan os.Signal, chan os.Signal) {
 	sigs := make(chan os.Signal, 10)
-	signal.Notify(sigs)
+	for s := syscall.Signal(1); s <= maxSignal; s++ {
+		if s != syscall.SIGCHLD {
+			signal.Notify(sigs, s)
+		}
+	}
+
+	chld := make(chan os.Signal, 1)
+	signal.Notify(chld, syscall.SIGCHLD)
 
 	// Ignore these to prevent losing the TTY
 	signal.Ignore(syscall.SIGTTIN, syscall.SIGTTOU)
@@ -16,5 +37,210 @@ func SetupSignals() chan os.Signal {
 	// These are for us and should not be proxied
 	signal.Reset(syscall.SIGFPE, syscall.SIGILL, syscall.SIGSEGV, syscall.SIGBUS, syscall.SIGABRT, syscall.SIGTRAP, syscall.SIGSYS)
 
-	return sigs
+	return sigs, chld
+}
+
+// Actions the supervisor takes when it receives a signal
+const (
+	SignalActionShutdown = "shutdown"
+	SignalActionReload   = "reload"
+	SignalActionRestart  = "restart"
+	SignalActionForward  = "forward"
+	SignalActionIgnore   = "ignore"
+)
+
+// defaultSignalActions are used for signals without an action in the
+// config. All other signals are forwarded to every job.
+var defaultSignalActions = SignalConfig{
+	syscall.SIGTERM: {Action: SignalActionShutdown},
+	syscall.SIGINT:  {Action: SignalActionShutdown},
+	syscall.SIGHUP:  {Action: SignalActionReload},
+
+	// Children are reaped by the supervisor, terminal size changes are
+	// meaningless to jobs without a terminal, and SIGURG is used by the
+	// Go runtime for preemption
+	syscall.SIGCHLD:  {Action: SignalActionIgnore},
+	syscall.SIGWINCH: {Action: SignalActionIgnore},
+	syscall.SIGURG:   {Action: SignalActionIgnore},
+}
+
+// SignalAction is the reaction of the supervisor to a signal. In config
+// files it is either the name of the action or an object with the action
+// and the jobs that the signal is forwarded to.
+type SignalAction struct {
+	Action string `json:"action"`
+
+	// Jobs are the names of the main jobs that a forwarded signal is sent
+	// to. If not set the signal is forwarded to every job.
+	Jobs []string `json:"jobs"`
+}
+
+func (a *SignalAction) UnmarshalJSON(b []byte) error {
+	type Alias SignalAction
+
+	if err := json.Unmarshal(b, &a.Action); err != nil {
+		if err := json.Unmarshal(b, (*Alias)(a)); err != nil {
+			return fmt.Errorf("SignalAction.UnmarshalJSON: %w", err)
+		}
+	}
+
+	switch a.Action {
+	case SignalActionShutdown, SignalActionReload, SignalActionRestart, SignalActionIgnore:
+		if len(a.Jobs) > 0 {
+			return fmt.Errorf("SignalAction.UnmarshalJSON: jobs are only valid for the %s action", SignalActionForward)
+		}
+	case SignalActionForward:
+	default:
+		return fmt.Errorf("SignalAction.UnmarshalJSON: invalid action %s", a.Action)
+	}
+
+	return nil
+}
+
+// SignalConfig maps signals received by the supervisor to actions. In
+// config files it is keyed by signal name, for example {"HUP": "reload"}.
+type SignalConfig map[syscall.Signal]*SignalAction
+
+func (c *SignalConfig) UnmarshalJSON(b []byte) error {
+	var m map[string]*SignalAction
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	*c = make(SignalConfig, len(m))
+	for name, a := range m {
+		sig, ok := signalMap[name]
+		if !ok {
+			return fmt.Errorf("SignalConfig.UnmarshalJSON: invalid signal %s", name)
+		}
+		if sig == syscall.SIGKILL || sig == syscall.SIGSTOP {
+			return fmt.Errorf("SignalConfig.UnmarshalJSON: signal %s can not be handled", name)
+		}
+		if a == nil {
+			return fmt.Errorf("SignalConfig.UnmarshalJSON: missing action for %s", name)
+		}
+		(*c)[sig] = a
+	}
+
+	return nil
+}
+
+// Action returns the action for a signal, falling back to the default
+// actions
+func (c SignalConfig) Action(sig os.Signal) *SignalAction {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return &SignalAction{Action: SignalActionForward}
+	}
+
+	if a, ok := c[s]; ok {
+		return a
+	}
+	if a, ok := defaultSignalActions[s]; ok {
+		return a
+	}
+
+	return &SignalAction{Action: SignalActionForward}
+}
+
+// Signal scopes determine which processes of a job receive signals
+const (
+	SignalScopeProcess = "process"
+	SignalScopeGroup   = "group"
+	SignalScopeSession = "session"
+)
+
+// Target in a signal map for signals that should not be delivered
+const signalIgnore = "ignore"
+
+// parseSignalMap parses a map of source signal names to target signal
+// names or ignore. Ignored signals map to 0.
+func parseSignalMap(m map[string]string) (map[syscall.Signal]syscall.Signal, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+
+	out := make(map[syscall.Signal]syscall.Signal, len(m))
+	for from, to := range m {
+		src, ok := signalMap[from]
+		if !ok {
+			return nil, fmt.Errorf("invalid signal %s", from)
+		}
+
+		if to == signalIgnore {
+			out[src] = 0
+			continue
+		}
+
+		if out[src], ok = signalMap[to]; !ok {
+			return nil, fmt.Errorf("invalid signal %s", to)
+		}
+	}
+
+	return out, nil
+}
+
+// signalName returns the name of a signal without the SIG prefix. Of
+// signals with several names the first in lexical order is used.
+func signalName(sig syscall.Signal) string {
+	var names []string
+	for n, s := range signalMap {
+		if s == sig {
+			names = append(names, n)
+		}
+	}
+	if len(names) == 0 {
+		return sig.String()
+	}
+	slices.Sort(names)
+	return names[0]
+}
+
+// signalSession sends a signal to every process in a session
+func signalSession(sid int, sig syscall.Signal) error {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if s, err := procSession(pid); err != nil || s != sid {
+			continue
+		}
+		if err := syscall.Kill(pid, sig); err == nil {
+			found = true
+		}
+	}
+
+	if !found {
+		return syscall.ESRCH
+	}
+
+	return nil
+}
+
+// procSession returns the session id of a process
+func procSession(pid int) (int, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// The command name may contain spaces and parentheses, the fields
+	// after it are state, ppid, pgrp, and session
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return 0, fmt.Errorf("invalid stat for %d", pid)
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 4 {
+		return 0, fmt.Errorf("invalid stat for %d", pid)
+	}
+
+	return strconv.Atoi(fields[3])
 }
@@ -0,0 +1,123 @@
+package supervise
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestProcSession(t *testing.T) {
+	sid, err := unix.Getsid(0)
+	assert.NoError(t, err)
+
+	s, err := procSession(os.Getpid())
+	assert.NoError(t, err)
+	assert.Equal(t, sid, s)
+}
+
+func TestSignalSession(t *testing.T) {
+	// The shell puts the background sleep in its own process group but
+	// it remains in the session
+	cmd := exec.Command("sh", "-c", "set -m; sleep 10 & wait")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	assert.NoError(t, cmd.Start())
+	time.Sleep(100 * time.Millisecond)
+
+	assert.NoError(t, signalSession(cmd.Process.Pid, syscall.SIGKILL))
+	cmd.Wait()
+
+	assert.Eventually(t, func() bool {
+		return !liveProcesses(t, 3, cmd.Process.Pid)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCommandHandleSignalScopeGroup(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 10 & wait")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	assert.NoError(t, cmd.Start())
+	time.Sleep(100 * time.Millisecond)
+
+	hnd := &CommandHandle{cmd: cmd, killsig: syscall.SIGTERM, signalScope: SignalScopeGroup}
+	assert.NoError(t, hnd.Stop(nil))
+	cmd.Wait()
+
+	// The background sleep was also terminated
+	assert.Eventually(t, func() bool {
+		return !liveProcesses(t, 2, cmd.Process.Pid)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCommandHandleKillStragglers(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 10 & exit 0")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	assert.NoError(t, cmd.Start())
+	cmd.Wait()
+
+	hnd := &CommandHandle{cmd: cmd, signalScope: SignalScopeProcess, exited: true}
+	assert.True(t, liveProcesses(t, 2, cmd.Process.Pid))
+	hnd.KillStragglers()
+
+	assert.Eventually(t, func() bool {
+		return !liveProcesses(t, 2, cmd.Process.Pid)
+	}, time.Second, 10*time.Millisecond)
+}
+
+// liveProcesses reports whether any process that is not a zombie has id
+// in the field of /proc/pid/stat after the command name, which is the
+// process group for field 2 and the session for field 3. Killed orphans
+// may remain as zombies if nothing reaps them.
+func liveProcesses(t *testing.T, field, id int) bool {
+	procs, err := os.ReadDir("/proc")
+	assert.NoError(t, err)
+
+	for _, p := range procs {
+		if _, err := strconv.Atoi(p.Name()); err != nil {
+			continue
+		}
+		stat, err := os.ReadFile("/proc/" + p.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+		if fields[0] != "Z" && fields[field] == strconv.Itoa(id) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestSignalConfigUnmarshal(t *testing.T) {
+	var c SignalConfig
+	assert.NoError(t, json.Unmarshal([]byte(`{"HUP": "reload", "USR1": {"action": "forward", "jobs": ["web"]}}`), &c))
+	assert.Equal(t, SignalConfig{
+		syscall.SIGHUP:  {Action: SignalActionReload},
+		syscall.SIGUSR1: {Action: SignalActionForward, Jobs: []string{"web"}},
+	}, c)
+
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"FOO": "ignore"}`), &c), "invalid signal FOO")
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"KILL": "ignore"}`), &c), "signal KILL can not be handled")
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"HUP": "explode"}`), &c), "invalid action explode")
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"HUP": {"action": "reload", "jobs": ["web"]}}`), &c), "jobs are only valid for the forward action")
+}
+
+func TestSignalConfigAction(t *testing.T) {
+	c := SignalConfig{syscall.SIGTERM: {Action: SignalActionIgnore}}
+
+	assert.Equal(t, SignalActionIgnore, c.Action(syscall.SIGTERM).Action)
+	assert.Equal(t, SignalActionShutdown, c.Action(syscall.SIGINT).Action)
+	assert.Equal(t, SignalActionIgnore, c.Action(syscall.SIGURG).Action)
+	assert.Equal(t, SignalActionIgnore, c.Action(syscall.SIGCHLD).Action)
+	assert.Equal(t, SignalActionReload, c.Action(syscall.SIGHUP).Action)
+	assert.Equal(t, SignalActionForward, c.Action(syscall.SIGUSR1).Action)
+	assert.Empty(t, c.Action(syscall.SIGUSR1).Jobs)
+}
@@ -0,0 +1,111 @@
+package supervise
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are the helper functions available to templates in
+// VaultTemplateVariables. They are mostly concerned with safely
+// encoding secrets into URLs and DSNs.
+var templateFuncs = template.FuncMap{
+	"pathEscape":  url.PathEscape,
+	"queryEscape": url.QueryEscape,
+	"userinfo":    templateUserinfo,
+	"b64enc":      templateB64Enc,
+	"b64dec":      templateB64Dec,
+	"toJson":      templateToJson,
+	"default":     templateDefault,
+	"required":    templateRequired,
+	"join":        templateJoin,
+}
+
+// templateUserinfo renders a username and optional password escaped for
+// the userinfo portion of a URL, for example user:p%40ss
+func templateUserinfo(user string, password ...string) (string, error) {
+	switch len(password) {
+	case 0:
+		return url.User(user).String(), nil
+	case 1:
+		return url.UserPassword(user, password[0]).String(), nil
+	default:
+		return "", fmt.Errorf("userinfo: expected at most one password")
+	}
+}
+
+func templateB64Enc(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func templateB64Dec(s string) (string, error) {
+	out, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func templateToJson(v any) (string, error) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// templateDefault returns v unless it is missing or empty, in which case
+// def is returned. The argument order allows {{ .FOO | default "bar" }}
+func templateDefault(def string, v ...any) string {
+	if len(v) == 0 || v[0] == nil || v[0] == "" {
+		return def
+	}
+	return fmt.Sprint(v[0])
+}
+
+// templateRequired fails the template with msg if v is missing or
+// empty. The argument order allows {{ .FOO | required "FOO is unset" }}
+func templateRequired(msg string, v ...any) (string, error) {
+	if len(v) == 0 || v[0] == nil || v[0] == "" {
+		return "", fmt.Errorf("%s", msg)
+	}
+	return fmt.Sprint(v[0]), nil
+}
+
+func templateJoin(sep string, elems ...string) string {
+	return strings.Join(elems, sep)
+}
+
+// templateContext builds the context for template rendering when
+// TemplateEnvironment is enabled. It contains the variables that will be
+// passed through to the subprocess overlaid with the vault replacements.
+// Template variables are excluded because they have not yet been
+// rendered.
+func templateContext(c *EnvConfig, env map[string]string, replacements map[string]string) map[string]string {
+	out := make(map[string]string, len(env))
+
+	if c.PassAllVariables {
+		for k, v := range env {
+			out[k] = v
+		}
+	} else {
+		for _, k := range c.PassVariables {
+			if v, ok := env[k]; ok {
+				out[k] = v
+			}
+		}
+	}
+
+	for _, k := range c.VaultTemplateVariables {
+		delete(out, k)
+	}
+
+	for k, v := range replacements {
+		out[k] = v
+	}
+
+	return out
+}
@@ -0,0 +1,57 @@
+package supervise
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	env := map[string]string{
+		"DSN":      "postgres://{{ userinfo .USER .PASS }}@db/app?sslcert={{ queryEscape .CERT }}",
+		"ENCODED":  "{{ b64enc .USER }}:{{ b64dec \"Zm9v\" }}",
+		"JSON":     "{{ toJson .PASS }}",
+		"DEFAULT":  "{{ .MISSING | default \"fallback\" }}:{{ .USER | default \"fallback\" }}",
+		"JOINED":   "{{ join \",\" .USER .PASS }}",
+		"PATH":     "/{{ pathEscape .PASS }}",
+		"REQUIRED": "{{ .MISSING | required \"MISSING is required\" }}",
+	}
+	ctx := map[string]string{
+		"USER":    "user",
+		"PASS":    "p@ss/w:rd",
+		"CERT":    "/etc/a b",
+		"MISSING": "",
+	}
+
+	assert.NoError(t, processTemplates(env, []string{"DSN", "ENCODED", "JSON", "DEFAULT", "JOINED", "PATH"}, ctx))
+	assert.Equal(t, "postgres://user:p%40ss%2Fw%3Ard@db/app?sslcert=%2Fetc%2Fa+b", env["DSN"])
+	assert.Equal(t, "dXNlcg==:foo", env["ENCODED"])
+	assert.Equal(t, `"p@ss/w:rd"`, env["JSON"])
+	assert.Equal(t, "fallback:user", env["DEFAULT"])
+	assert.Equal(t, "user,p@ss/w:rd", env["JOINED"])
+	assert.Equal(t, "/p@ss%2Fw:rd", env["PATH"])
+
+	assert.ErrorContains(t, processTemplates(env, []string{"REQUIRED"}, ctx), "MISSING is required")
+}
+
+func TestTemplateContext(t *testing.T) {
+	env := map[string]string{
+		"HOST":     "db.example.com",
+		"OTHER":    "other",
+		"PASS":     "secret",
+		"TEMPLATE": "{{ .HOST }}",
+	}
+	replacements := map[string]string{"PASS": "resolved"}
+
+	ctx := templateContext(&EnvConfig{
+		PassVariables:          []string{"HOST", "PASS", "TEMPLATE"},
+		VaultTemplateVariables: []string{"TEMPLATE"},
+	}, env, replacements)
+	assert.Equal(t, map[string]string{"HOST": "db.example.com", "PASS": "resolved"}, ctx)
+
+	ctx = templateContext(&EnvConfig{
+		PassAllVariables:       true,
+		VaultTemplateVariables: []string{"TEMPLATE"},
+	}, env, replacements)
+	assert.Equal(t, map[string]string{"HOST": "db.example.com", "OTHER": "other", "PASS": "resolved"}, ctx)
+}
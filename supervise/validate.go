@@ -0,0 +1,302 @@
+package supervise
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// configShapes maps types with custom JSON decoding to a type with the
+// fields of their config file representation
+var configShapes = map[reflect.Type]reflect.Type{
+	reflect.TypeFor[Command](): reflect.TypeFor[commandConfig](),
+}
+
+var jsonUnmarshaler = reflect.TypeFor[json.Unmarshaler]()
+
+// parseRawConfig parses a config into generic JSON values. Numbers are
+// kept as json.Number so they can be encoded again without loss.
+func parseRawConfig(b []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if d.More() {
+		return nil, fmt.Errorf("unexpected data after config")
+	}
+	return v, nil
+}
+
+// configErrors checks the generic JSON value v against type t. It returns
+// an error for every field that does not exist in t and for every value
+// of a type with custom decoding that can not be decoded, with the path
+// of the value in the config. Invalid values are reported at the deepest
+// path possible. Other type mismatches are left for decoding to report.
+func configErrors(v any, t reflect.Type, path string) (unknown, invalid []error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	shape := t
+	if s, ok := configShapes[t]; ok {
+		shape = s
+	}
+
+	descend := func(v any, t reflect.Type, path string) {
+		u, i := configErrors(v, t, path)
+		unknown = append(unknown, u...)
+		invalid = append(invalid, i...)
+	}
+
+	switch shape.Kind() {
+	case reflect.Struct:
+		if obj, ok := v.(map[string]any); ok {
+			fields := jsonFields(shape)
+			for _, k := range sortedKeys(obj) {
+				f, ok := lookupJSONField(fields, k)
+				if !ok {
+					unknown = append(unknown, fmt.Errorf("%s: unknown field", joinConfigPath(path, k)))
+					continue
+				}
+				descend(obj[k], f.Type, joinConfigPath(path, k))
+			}
+		}
+	case reflect.Map:
+		if obj, ok := v.(map[string]any); ok {
+			for _, k := range sortedKeys(obj) {
+				descend(obj[k], shape.Elem(), joinConfigPath(path, k))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if arr, ok := v.([]any); ok {
+			for i, e := range arr {
+				descend(e, shape.Elem(), fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	}
+
+	if len(invalid) == 0 && reflect.PointerTo(t).Implements(jsonUnmarshaler) {
+		b, err := json.Marshal(v)
+		if err == nil {
+			err = json.Unmarshal(b, reflect.New(t).Interface())
+		}
+		if err != nil {
+			invalid = append(invalid, fmt.Errorf("%s: %w", path, err))
+		}
+	}
+
+	return unknown, invalid
+}
+
+// jsonField is a field of a config struct. Parent is the struct that
+// declares the field, which is an embedded struct for promoted fields.
+type jsonField struct {
+	reflect.StructField
+	parent reflect.Type
+}
+
+// jsonFields returns the fields of a struct by JSON name, following the
+// rules of encoding/json for embedded structs
+func jsonFields(t reflect.Type) map[string]jsonField {
+	fields := map[string]jsonField{}
+
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			embedded = append(embedded, ft)
+			continue
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = jsonField{f, t}
+	}
+
+	// Fields of embedded structs are hidden by fields of the outer struct
+	for _, e := range embedded {
+		for name, f := range jsonFields(e) {
+			if _, ok := fields[name]; !ok {
+				fields[name] = f
+			}
+		}
+	}
+
+	return fields
+}
+
+// lookupJSONField finds a field by name, preferring an exact match but
+// otherwise ignoring case like encoding/json
+func lookupJSONField(fields map[string]jsonField, name string) (jsonField, bool) {
+	if f, ok := fields[name]; ok {
+		return f, true
+	}
+	for n, f := range fields {
+		if strings.EqualFold(n, name) {
+			return f, true
+		}
+	}
+	return jsonField{}, false
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinConfigPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// validate checks the config for errors that do not depend on the host
+// that the supervisor runs on
+func (c *AppConfig) validate() []error {
+	var errs []error
+
+	names := map[string]string{}
+	check := func(js *Command, path string) {
+		if len(js.Command) == 0 || js.Command[0] == "" {
+			errs = append(errs, fmt.Errorf("%s: cmd is required", path))
+		}
+		if err := js.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+
+		// Names default to the command so are only missing without one
+		if js.Name == "" {
+			return
+		}
+		if other, ok := names[js.Name]; ok {
+			errs = append(errs, fmt.Errorf("%s: name %s is already used by %s, jobs must have unique names", path, js.Name, other))
+			return
+		}
+		names[js.Name] = path
+	}
+
+	for _, job := range configJobs(c) {
+		check(job.command, job.path)
+	}
+
+	if c.Vault != nil {
+		if err := c.Vault.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("vault.%w", err))
+		}
+	}
+
+	for _, sig := range slices.Sorted(maps.Keys(c.Signals)) {
+		for _, name := range c.Signals[sig].Jobs {
+			if !slices.ContainsFunc(c.Jobs.Main, func(js *Command) bool { return js.Name == name }) {
+				errs = append(errs, fmt.Errorf("signals.%s: unknown main job %s", signalName(sig), name))
+			}
+		}
+	}
+
+	return errs
+}
+
+// validate checks the fields of a job that depend on each other. It runs
+// once the job is complete, after includes are merged and templates are
+// applied.
+func (c *Command) validate() error {
+	if c.Hostname != "" && !slices.Contains(c.Namespaces, "uts") {
+		return fmt.Errorf("hostname requires a uts namespace")
+	}
+	return nil
+}
+
+// validate checks that the fields required by the auth method are set.
+// Errors are prefixed with the name of the field.
+func (c *VaultConfig) validate() error {
+	switch c.AuthMethod {
+	case "kubernetes", "jwt":
+		if c.Role == "" {
+			return fmt.Errorf("role: required for auth method %s", c.AuthMethod)
+		}
+	}
+	return nil
+}
+
+// CheckConfig reads and validates the config at path. In addition to the
+// validation done when the config is read, it checks that the users and
+// groups of jobs exist and that seccomp profiles can be loaded on this
+// host. These are not checked when the supervisor starts because init
+// jobs may create them. All errors found are returned.
+func CheckConfig(path string) error {
+	cfg, err := ReadAppConfig(path)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, job := range configJobs(cfg) {
+		js, path := job.command, job.path
+		if _, err := getUid(js.RunAsUser); err != nil {
+			errs = append(errs, fmt.Errorf("%s.run-as: %w", path, err))
+		}
+		if _, err := getGid(js.RunAsGroup); err != nil {
+			errs = append(errs, fmt.Errorf("%s.run-as: %w", path, err))
+		}
+		if _, err := getGroups(js.RunAsUser, js.Groups); err != nil {
+			errs = append(errs, fmt.Errorf("%s.groups: %w", path, err))
+		}
+		if js.Seccomp != "" {
+			if _, err := loadSeccompProfile(js.Seccomp); err != nil {
+				errs = append(errs, fmt.Errorf("%s.seccomp: %w", path, err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("CheckConfig: invalid config:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+type configJob struct {
+	command *Command
+	path    string
+}
+
+// configJobs returns every job in the config with its path in the config
+func configJobs(cfg *AppConfig) []configJob {
+	var out []configJob
+	for i, js := range cfg.Jobs.Init {
+		out = append(out, configJob{js, fmt.Sprintf("jobs.init[%d]", i)})
+	}
+	for i, js := range cfg.Jobs.Main {
+		out = append(out, configJob{js, fmt.Sprintf("jobs.main[%d]", i)})
+	}
+	return out
+}
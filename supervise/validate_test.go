@@ -0,0 +1,99 @@
+package supervise
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestConfig(t *testing.T, cfg string) string {
+	return writeTestConfigFile(t, "simplevisor.json", cfg)
+}
+
+func TestReadAppConfigEmpty(t *testing.T) {
+	cfg, err := ReadAppConfig(writeTestConfig(t, `{}`))
+	assert.NoError(t, err)
+	assert.NotNil(t, cfg.Environment)
+	assert.NotNil(t, cfg.Jobs)
+}
+
+func TestReadAppConfigUnknownFields(t *testing.T) {
+	_, err := ReadAppConfig(writeTestConfig(t, `{
+		"jobs": {"main": [{"cmd": ["web"], "kill_signal": "TERM", "capabilities": {"dorp": []}}]},
+		"vault": {"adress": "https://vault"},
+		"env": {"pass": ["HOME"]}
+	}`))
+	assert.EqualError(t, err, "readConfig: invalid config:\n"+
+		"jobs.main[0].capabilities.dorp: unknown field\n"+
+		"jobs.main[0].kill_signal: unknown field\n"+
+		"vault.adress: unknown field")
+}
+
+func TestReadAppConfigFieldCase(t *testing.T) {
+	// Like encoding/json field names are matched without case and fields
+	// that are parsed from another field can not be set directly
+	_, err := ReadAppConfig(writeTestConfig(t, `{"jobs": {"main": [{"CMD": ["web"], "Kill-Signal": "TERM"}]}}`))
+	assert.NoError(t, err)
+
+	_, err = ReadAppConfig(writeTestConfig(t, `{"jobs": {"main": [{"cmd": ["web"], "KillSignal": 15}]}}`))
+	assert.ErrorContains(t, err, "jobs.main[0].KillSignal: unknown field")
+}
+
+func TestReadAppConfigInvalidValues(t *testing.T) {
+	_, err := ReadAppConfig(writeTestConfig(t, `{
+		"jobs": {"main": [
+			{"cmd": ["web"], "rlimits": {"nofile": "10:5"}},
+			{"cmd": ["worker"], "kill-signal": "FOO"},
+			{"cmd": ["cron"], "rlimits": {"nofile": 18446744073709551615}}
+		]},
+		"signals": {"HUP": "explode", "FOO": "ignore"}
+	}`))
+	assert.EqualError(t, err, "readConfig: invalid config:\n"+
+		"jobs.main[0].rlimits.nofile: Rlimit.UnmarshalJSON: soft limit 10 exceeds hard limit 5\n"+
+		"jobs.main[1]: Command.UnmarshalJSON: invalid signal FOO\n"+
+		"signals.HUP: SignalAction.UnmarshalJSON: invalid action explode")
+
+	_, err = ReadAppConfig(writeTestConfig(t, `{"jobs": {"main": [{"cmd": "web"}]}}`))
+	assert.ErrorContains(t, err, "jobs.main[0]: json: cannot unmarshal string")
+
+	_, err = ReadAppConfig(writeTestConfig(t, `{"jobs": []}`))
+	assert.ErrorContains(t, err, "json: cannot unmarshal array")
+
+	_, err = ReadAppConfig(writeTestConfig(t, `{"jobs": {}} {}`))
+	assert.ErrorContains(t, err, "unable to parse config")
+}
+
+func TestReadAppConfigValidate(t *testing.T) {
+	_, err := ReadAppConfig(writeTestConfig(t, `{
+		"jobs": {
+			"init": [{"cmd": ["/bin/migrate"]}],
+			"main": [
+				{"cmd": []},
+				{"name": "migrate", "cmd": ["web"]},
+				{"cmd": ["worker"]}
+			]
+		},
+		"signals": {"USR1": {"action": "forward", "jobs": ["worker", "cron"]}},
+		"vault": {"auth-method": "kubernetes"}
+	}`))
+	assert.EqualError(t, err, "readConfig: invalid config:\n"+
+		"jobs.main[0]: cmd is required\n"+
+		"jobs.main[1]: name migrate is already used by jobs.init[0], jobs must have unique names\n"+
+		"vault.role: required for auth method kubernetes\n"+
+		"signals.USR1: unknown main job cron")
+
+	_, err = ReadAppConfig(writeTestConfig(t, `{"vault": {"auth-method": "jwt", "role": "app"}}`))
+	assert.NoError(t, err)
+}
+
+func TestCheckConfig(t *testing.T) {
+	assert.NoError(t, CheckConfig(writeTestConfig(t, `{"jobs": {"main": [{"cmd": ["web"], "run-as": "root:root"}]}}`)))
+
+	err := CheckConfig(writeTestConfig(t, `{"jobs": {"main": [
+		{"cmd": ["web"], "run-as": "nosuchuser:root"},
+		{"cmd": ["worker"], "groups": ["nosuchgroup"], "seccomp": "missing.json"}
+	]}}`))
+	assert.ErrorContains(t, err, "jobs.main[0].run-as: user: unknown user nosuchuser")
+	assert.ErrorContains(t, err, "jobs.main[1].groups: group: unknown group nosuchgroup")
+	assert.ErrorContains(t, err, "jobs.main[1].seccomp: ")
+}
@@ -0,0 +1,285 @@
+package supervise
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	vaultLoginTimeout          = 30 * time.Second
+)
+
+// Default mount points for each auth method, these match the Vault
+// defaults when enabling the auth method without a path
+var vaultAuthMounts = map[string]string{
+	"approle":    "approle",
+	"kubernetes": "kubernetes",
+	"jwt":        "jwt",
+	"cert":       "cert",
+}
+
+type vaultAuthResponse struct {
+	Auth *struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Data   map[string]any `json:"data"`
+	Errors []string       `json:"errors"`
+}
+
+// Login performs the configured auth method against Vault and exports
+// the resulting token as VAULT_TOKEN for the secrets client. When the
+// auth method is empty or token this does nothing and the secrets client
+// will use VAULT_TOKEN or VAULT_ROLE_ID and VAULT_SECRET_ID from the
+// environment.
+func (c *VaultConfig) Login(ctx context.Context) error {
+	if c.Address != "" {
+		os.Setenv("VAULT_ADDR", c.Address)
+	}
+	if c.CACert != "" {
+		os.Setenv("VAULT_CACERT", c.CACert)
+	}
+
+	if !c.usesLogin() {
+		return nil
+	}
+
+	mount, ok := vaultAuthMounts[c.AuthMethod]
+	if !ok {
+		return fmt.Errorf("VaultConfig.Login: unknown auth method %s", c.AuthMethod)
+	}
+	if c.Mount != "" {
+		mount = strings.Trim(c.Mount, "/")
+	}
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return fmt.Errorf("VaultConfig.Login: auth method %s requires address or VAULT_ADDR", c.AuthMethod)
+	}
+
+	client, err := c.httpClient()
+	if err != nil {
+		return err
+	}
+
+	body := map[string]string{}
+	switch c.AuthMethod {
+	case "approle":
+		if body["role_id"], err = c.roleId(); err != nil {
+			return err
+		}
+		if body["secret_id"], err = c.secretId(ctx, client, addr); err != nil {
+			return err
+		}
+	case "kubernetes", "jwt":
+		tokenFile := c.TokenFile
+		if tokenFile == "" && c.AuthMethod == "kubernetes" {
+			tokenFile = defaultKubernetesTokenFile
+		}
+		if tokenFile == "" {
+			return fmt.Errorf("VaultConfig.Login: auth method jwt requires token-file")
+		}
+		if body["jwt"], err = readSecretFile(tokenFile); err != nil {
+			return err
+		}
+		body["role"] = c.Role
+	case "cert":
+		if c.Role != "" {
+			body["name"] = c.Role
+		}
+	}
+
+	res, err := vaultRequest(ctx, client, addr, "auth/"+mount+"/login", "", body)
+	if err != nil {
+		return fmt.Errorf("VaultConfig.Login: %s login failed: %w", c.AuthMethod, err)
+	}
+	if res.Auth == nil || res.Auth.ClientToken == "" {
+		return fmt.Errorf("VaultConfig.Login: %s login returned no token", c.AuthMethod)
+	}
+
+	// The token supersedes any AppRole credentials in the environment
+	os.Unsetenv("VAULT_ROLE_ID")
+	os.Unsetenv("VAULT_SECRET_ID")
+	os.Setenv("VAULT_TOKEN", res.Auth.ClientToken)
+
+	return nil
+}
+
+// ClearToken removes a token obtained by Login from the supervisor
+// environment so that it is not passed through to jobs. It must only be
+// called after the secrets client has authenticated.
+func (c *VaultConfig) ClearToken() {
+	if c.usesLogin() {
+		os.Unsetenv("VAULT_TOKEN")
+	}
+}
+
+func (c *VaultConfig) usesLogin() bool {
+	return c.AuthMethod != "" && c.AuthMethod != "token"
+}
+
+func (c *VaultConfig) roleId() (string, error) {
+	switch {
+	case c.RoleId != "":
+		return c.RoleId, nil
+	case c.RoleIdFile != "":
+		return readSecretFile(c.RoleIdFile)
+	case os.Getenv("VAULT_ROLE_ID") != "":
+		return os.Getenv("VAULT_ROLE_ID"), nil
+	default:
+		return "", fmt.Errorf("VaultConfig.Login: approle requires role-id, role-id-file, or VAULT_ROLE_ID")
+	}
+}
+
+// secretId reads the AppRole secret-id from a file or the environment.
+// If the secret-id is response wrapped it is unwrapped with Vault before
+// use, only once even if the login is retried.
+func (c *VaultConfig) secretId(ctx context.Context, client *http.Client, addr string) (string, error) {
+	if c.unwrappedSecretId != "" {
+		return c.unwrappedSecretId, nil
+	}
+
+	var secretId string
+	var err error
+
+	switch {
+	case c.SecretIdFile != "":
+		if secretId, err = readSecretFile(c.SecretIdFile); err != nil {
+			return "", err
+		}
+	case os.Getenv("VAULT_SECRET_ID") != "":
+		secretId = os.Getenv("VAULT_SECRET_ID")
+	default:
+		return "", fmt.Errorf("VaultConfig.Login: approle requires secret-id-file or VAULT_SECRET_ID")
+	}
+
+	if !c.SecretIdWrapped {
+		return secretId, nil
+	}
+
+	res, err := vaultRequest(ctx, client, addr, "sys/wrapping/unwrap", secretId, nil)
+	if err != nil {
+		return "", fmt.Errorf("VaultConfig.Login: unable to unwrap secret-id: %w", err)
+	}
+	unwrapped, ok := res.Data["secret_id"].(string)
+	if !ok || unwrapped == "" {
+		return "", fmt.Errorf("VaultConfig.Login: wrapped response contains no secret_id")
+	}
+	c.unwrappedSecretId = unwrapped
+
+	return unwrapped, nil
+}
+
+func (c *VaultConfig) httpClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+
+	if c.CACert != "" {
+		pem, err := os.ReadFile(c.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("VaultConfig.Login: unable to read ca-cert: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("VaultConfig.Login: no certificates found in ca-cert %s", c.CACert)
+		}
+	}
+
+	if c.AuthMethod == "cert" {
+		if c.ClientCert == "" || c.ClientKey == "" {
+			return nil, fmt.Errorf("VaultConfig.Login: auth method cert requires client-cert and client-key")
+		}
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("VaultConfig.Login: unable to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{
+		Timeout:   vaultLoginTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+func vaultRequest(ctx context.Context, client *http.Client, addr, path, token string, body any) (*vaultAuthResponse, error) {
+	buf := &bytes.Buffer{}
+	if body != nil {
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(addr, "/")+"/v1/"+path, buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	out := &vaultAuthResponse{}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return nil, fmt.Errorf("unable to decode response (status %d): %w", res.StatusCode, err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, &vaultResponseError{StatusCode: res.StatusCode, Errors: out.Errors}
+	}
+
+	return out, nil
+}
+
+// vaultResponseError is an error response to a Vault request
+type vaultResponseError struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *vaultResponseError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, strings.Join(e.Errors, ", "))
+}
+
+// vaultResponseCode matches the status code in the errors of the Vault API
+// client used by the secrets client
+var vaultResponseCode = regexp.MustCompile(`\bCode: ([0-9]{3})\b`)
+
+// vaultStatusCode returns the HTTP status code of the failed Vault request
+// that caused err, if it is known
+func vaultStatusCode(err error) (int, bool) {
+	if re := (*vaultResponseError)(nil); errors.As(err, &re) {
+		return re.StatusCode, true
+	}
+
+	m := vaultResponseCode.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0, false
+	}
+	code, _ := strconv.Atoi(m[1])
+	return code, true
+}
+
+func readSecretFile(path string) (string, error) {
+	v, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("VaultConfig.Login: unable to read %s: %w", path, err)
+	}
+	return strings.TrimSpace(string(v)), nil
+}
@@ -0,0 +1,188 @@
+package supervise
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type VaultLoginSuite struct {
+	suite.Suite
+	srv      *httptest.Server
+	requests map[string]map[string]string
+	tokens   map[string]string
+	dir      string
+
+	unwraps     int
+	flakyLogins int
+}
+
+func (s *VaultLoginSuite) SetupTest() {
+	s.requests = map[string]map[string]string{}
+	s.tokens = map[string]string{}
+	s.dir = s.T().TempDir()
+	s.unwraps, s.flakyLogins = 0, 0
+
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.requests[r.URL.Path] = body
+		s.tokens[r.URL.Path] = r.Header.Get("X-Vault-Token")
+
+		switch r.URL.Path {
+		case "/v1/sys/wrapping/unwrap":
+			// Wrapping tokens can only be used once
+			if s.unwraps++; s.unwraps > 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors": ["wrapping token is not valid or does not exist"]}`))
+				return
+			}
+			w.Write([]byte(`{"data": {"secret_id": "unwrapped-secret"}}`))
+		case "/v1/auth/flaky/login":
+			if s.flakyLogins++; s.flakyLogins == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"errors": ["Vault is sealed"]}`))
+				return
+			}
+			w.Write([]byte(`{"auth": {"client_token": "login-token"}}`))
+		case "/v1/auth/fail/login":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+		default:
+			w.Write([]byte(`{"auth": {"client_token": "login-token"}}`))
+		}
+	}))
+
+	for _, k := range []string{"VAULT_ADDR", "VAULT_TOKEN", "VAULT_ROLE_ID", "VAULT_SECRET_ID", "VAULT_CACERT"} {
+		s.T().Setenv(k, "")
+		os.Unsetenv(k)
+	}
+}
+
+func (s *VaultLoginSuite) TearDownTest() {
+	s.srv.Close()
+}
+
+func (s *VaultLoginSuite) writeFile(name, content string) string {
+	p := filepath.Join(s.dir, name)
+	assert.NoError(s.T(), os.WriteFile(p, []byte(content+"\n"), 0600))
+	return p
+}
+
+func (s *VaultLoginSuite) TestTokenIsNoop() {
+	assert.NoError(s.T(), (&VaultConfig{}).Login(context.TODO()))
+	assert.NoError(s.T(), (&VaultConfig{AuthMethod: "token"}).Login(context.TODO()))
+	assert.Empty(s.T(), s.requests)
+	assert.Equal(s.T(), "", os.Getenv("VAULT_TOKEN"))
+}
+
+func (s *VaultLoginSuite) TestKubernetes() {
+	c := &VaultConfig{
+		Address:    s.srv.URL,
+		AuthMethod: "kubernetes",
+		Role:       "app",
+		TokenFile:  s.writeFile("jwt", "k8s-jwt"),
+	}
+	assert.NoError(s.T(), c.Login(context.TODO()))
+	assert.Equal(s.T(), map[string]string{"role": "app", "jwt": "k8s-jwt"}, s.requests["/v1/auth/kubernetes/login"])
+	assert.Equal(s.T(), "login-token", os.Getenv("VAULT_TOKEN"))
+	assert.Equal(s.T(), s.srv.URL, os.Getenv("VAULT_ADDR"))
+
+	c.ClearToken()
+	assert.Equal(s.T(), "", os.Getenv("VAULT_TOKEN"))
+}
+
+func (s *VaultLoginSuite) TestJwtCustomMount() {
+	c := &VaultConfig{
+		Address:    s.srv.URL,
+		AuthMethod: "jwt",
+		Mount:      "/oidc/",
+		Role:       "app",
+		TokenFile:  s.writeFile("jwt", "oidc-jwt"),
+	}
+	assert.NoError(s.T(), c.Login(context.TODO()))
+	assert.Equal(s.T(), map[string]string{"role": "app", "jwt": "oidc-jwt"}, s.requests["/v1/auth/oidc/login"])
+}
+
+func (s *VaultLoginSuite) TestJwtRequiresTokenFile() {
+	c := &VaultConfig{Address: s.srv.URL, AuthMethod: "jwt"}
+	assert.ErrorContains(s.T(), c.Login(context.TODO()), "requires token-file")
+}
+
+func (s *VaultLoginSuite) TestAppRoleWrapped() {
+	os.Setenv("VAULT_ROLE_ID", "env-role")
+	c := &VaultConfig{
+		Address:         s.srv.URL,
+		AuthMethod:      "approle",
+		SecretIdFile:    s.writeFile("secret-id", "wrapping-token"),
+		SecretIdWrapped: true,
+	}
+	assert.NoError(s.T(), c.Login(context.TODO()))
+	assert.Equal(s.T(), "wrapping-token", s.tokens["/v1/sys/wrapping/unwrap"])
+	assert.Equal(s.T(), map[string]string{
+		"role_id":   "env-role",
+		"secret_id": "unwrapped-secret",
+	}, s.requests["/v1/auth/approle/login"])
+	assert.Equal(s.T(), "", os.Getenv("VAULT_ROLE_ID"))
+}
+
+func (s *VaultLoginSuite) TestAppRoleWrappedRetry() {
+	os.Setenv("VAULT_ROLE_ID", "env-role")
+	c := &VaultConfig{
+		Address:         s.srv.URL,
+		AuthMethod:      "approle",
+		Mount:           "flaky",
+		SecretIdFile:    s.writeFile("secret-id", "wrapping-token"),
+		SecretIdWrapped: true,
+		StartupRetry:    &RetryConfig{InitialBackoff: Duration(time.Millisecond), Deadline: Duration(time.Second)},
+	}
+
+	r := newStartupRetrier(c.StartupRetry, s.T().Logf)
+	assert.NoError(s.T(), r.Do(context.TODO(), "vault login", func() error { return c.Login(context.TODO()) }))
+	assert.Equal(s.T(), 1, s.unwraps)
+	assert.Equal(s.T(), 2, s.flakyLogins)
+	assert.Equal(s.T(), "unwrapped-secret", s.requests["/v1/auth/flaky/login"]["secret_id"])
+	assert.Equal(s.T(), "login-token", os.Getenv("VAULT_TOKEN"))
+}
+
+func (s *VaultLoginSuite) TestAppRoleMissingSecretId() {
+	c := &VaultConfig{Address: s.srv.URL, AuthMethod: "approle", RoleId: "role"}
+	assert.ErrorContains(s.T(), c.Login(context.TODO()), "approle requires secret-id-file or VAULT_SECRET_ID")
+}
+
+func (s *VaultLoginSuite) TestCertRequiresKeyPair() {
+	c := &VaultConfig{Address: s.srv.URL, AuthMethod: "cert"}
+	assert.ErrorContains(s.T(), c.Login(context.TODO()), "requires client-cert and client-key")
+}
+
+func (s *VaultLoginSuite) TestLoginError() {
+	c := &VaultConfig{
+		Address:    s.srv.URL,
+		AuthMethod: "jwt",
+		Mount:      "fail",
+		TokenFile:  s.writeFile("jwt", "jwt"),
+	}
+	assert.ErrorContains(s.T(), c.Login(context.TODO()), "jwt login failed: status 400: permission denied")
+}
+
+func (s *VaultLoginSuite) TestUnknownMethod() {
+	c := &VaultConfig{Address: s.srv.URL, AuthMethod: "bogus"}
+	assert.ErrorContains(s.T(), c.Login(context.TODO()), "unknown auth method bogus")
+}
+
+func (s *VaultLoginSuite) TestRequiresAddress() {
+	c := &VaultConfig{AuthMethod: "kubernetes"}
+	assert.ErrorContains(s.T(), c.Login(context.TODO()), "requires address or VAULT_ADDR")
+}
+
+func TestVaultLoginSuite(t *testing.T) {
+	suite.Run(t, &VaultLoginSuite{})
+}